@@ -0,0 +1,553 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// This file contains the versioned JSON API mounted at /api/v1
+// All requests are authenticated with a personal API token created from /user
+
+// maximum size of a request body accepted by the API
+const apiMaxBodySize = 1 << 20
+
+type apiHandlerFunc func(http.ResponseWriter, *http.Request, *Setting)
+
+// apiError is the structured error returned instead of flash messages
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiRepo struct {
+	Repo       string   `json:"repo"`
+	References []string `json:"references"`
+	Branches   bool     `json:"new_branches"`
+	Tags       bool     `json:"new_tags"`
+	Channels   []string `json:"channels"`
+}
+
+type apiOrg struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Channels []string `json:"channels"`
+}
+
+type apiNotification struct {
+	Email        string        `json:"email"`
+	Name         string        `json:"name"`
+	Disabled     bool          `json:"disabled"`
+	TimeZone     string        `json:"tz"`
+	TimeZoneName string        `json:"tzname"`
+	Hour         string        `json:"hour"`
+	WeekDay      string        `json:"weekday"`
+	PerRepoEmail bool          `json:"per_repo_emails"`
+	Channels     []*apiChannel `json:"channels"`
+	// read only. address waiting for the confirmation link to be opened, and addresses which are not emailed after a bounce or complaint
+	PendingEmail  string   `json:"pending_email,omitempty"`
+	InvalidEmails []string `json:"invalid_emails,omitempty"`
+}
+
+type apiChannel struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Target    string   `json:"target"`
+	Enabled   bool     `json:"enabled"`
+	Repos     []string `json:"repos"`
+	Recipient string   `json:"recipient,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Token     string   `json:"token,omitempty"` // write only, the saved token is retained when empty
+	HasToken  bool     `json:"has_token"`
+	Secret    string   `json:"secret,omitempty"` // write only, the saved secret is retained when empty
+	HasSecret bool     `json:"has_secret"`
+	Template  string   `json:"template,omitempty"`
+	Priority  string   `json:"priority,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Pending   bool     `json:"pending,omitempty"` // read only, email channel waiting for the confirmation link to be opened
+}
+
+type apiDiffSummary struct {
+	ID        string    `json:"id"`
+	Display   string    `json:"display"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func initAPI(p *mux.Router) {
+	p.HandleFunc("/repos", withAPIAuth(apiListRepos)).Methods("GET")
+	p.HandleFunc("/repos", withAPIAuth(apiCreateRepo)).Methods("POST")
+	p.HandleFunc("/repos/{owner}/{name}", withAPIAuth(apiShowRepo)).Methods("GET")
+	p.HandleFunc("/repos/{owner}/{name}", withAPIAuth(apiUpdateRepo)).Methods("PUT")
+	p.HandleFunc("/repos/{owner}/{name}", withAPIAuth(apiDeleteRepo)).Methods("DELETE")
+
+	p.HandleFunc("/orgs", withAPIAuth(apiListOrgs)).Methods("GET")
+	p.HandleFunc("/orgs", withAPIAuth(apiCreateOrg)).Methods("POST")
+	p.HandleFunc("/orgs/{name}", withAPIAuth(apiShowOrg)).Methods("GET")
+	p.HandleFunc("/orgs/{name}", withAPIAuth(apiUpdateOrg)).Methods("PUT")
+	p.HandleFunc("/orgs/{name}", withAPIAuth(apiDeleteOrg)).Methods("DELETE")
+
+	p.HandleFunc("/notifications", withAPIAuth(apiShowNotification)).Methods("GET")
+	p.HandleFunc("/notifications", withAPIAuth(apiUpdateNotification)).Methods("PUT")
+
+	p.HandleFunc("/diffs", withAPIAuth(apiListDiffs)).Methods("GET")
+	p.HandleFunc("/diffs/{id:[0-9]+}", withAPIAuth(apiShowDiff)).Methods("GET")
+
+	p.HandleFunc("/run", withAPIAuth(apiRun)).Methods("POST")
+}
+
+func withAPIAuth(h apiHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := apiTokenFromRequest(r)
+		// the changes are made to the settings loaded and saved under the lock of the file
+		if r.Method != "GET" {
+			if configFile := apiTokenConfigFile(token); configFile != "" {
+				defer lockSetting(configFile)()
+			}
+		}
+		conf, err := settingForAPIToken(token)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodySize)
+		h(w, r, conf)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]*apiError{"error": {Code: code, Message: message}})
+}
+
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "Request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func saveAPISetting(w http.ResponseWriter, conf *Setting) bool {
+	if err := conf.save(conf.Auth.getConfigFile()); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "save_failed", "Error saving configuration "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Repositories
+
+func repoToAPI(repo *Repo) *apiRepo {
+	references := make([]string, 0, len(repo.NamedReferences))
+	for _, ref := range repo.NamedReferences {
+		references = append(references, string(ref))
+	}
+	return &apiRepo{
+		Repo:       repo.Repo,
+		References: references,
+		Branches:   repo.Branches,
+		Tags:       repo.Tags,
+		Channels:   nonNilStrings(repo.Channels),
+	}
+}
+
+func findRepo(conf *Setting, repoName string) *Repo {
+	for _, repo := range conf.Repos {
+		if repo.Repo == repoName {
+			return repo
+		}
+	}
+	return nil
+}
+
+func apiRepoName(r *http.Request) string {
+	vars := mux.Vars(r)
+	return validateRepoName(vars["owner"] + "/" + vars["name"])
+}
+
+func apiListRepos(w http.ResponseWriter, _ *http.Request, conf *Setting) {
+	repos := make([]*apiRepo, 0, len(conf.Repos))
+	for _, repo := range conf.Repos {
+		repos = append(repos, repoToAPI(repo))
+	}
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func apiShowRepo(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	repo := findRepo(conf, apiRepoName(r))
+	if repo == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Repository is not being tracked")
+		return
+	}
+	writeJSON(w, http.StatusOK, repoToAPI(repo))
+}
+
+func apiCreateRepo(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	in := &apiRepo{}
+	if !decodeAPIBody(w, r, in) {
+		return
+	}
+	apiSaveRepo(w, conf, validateRepoName(in.Repo), in)
+}
+
+func apiUpdateRepo(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	in := &apiRepo{}
+	if !decodeAPIBody(w, r, in) {
+		return
+	}
+	apiSaveRepo(w, conf, apiRepoName(r), in)
+}
+
+func apiSaveRepo(w http.ResponseWriter, conf *Setting, repoName string, in *apiRepo) {
+	var provider = conf.Auth.Provider
+
+	if repoName == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_repo", "Invalid Repo Name Provided")
+		return
+	}
+
+	if !validateRemoteRepoName(provider, conf.Auth.Token, repoName) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "remote_not_found", "Could not find Repo on "+provider)
+		return
+	}
+
+	var references []reference
+	for _, t := range in.References {
+		str := strings.TrimSpace(t)
+		if str == "" {
+			continue
+		}
+		references = append(references, reference(str))
+	}
+
+	repo := &Repo{
+		repoName,
+		references,
+		in.Branches,
+		in.Tags,
+		provider,
+		conf.cleanRoutes(in.Channels),
+	}
+
+	created := upsertRepo(conf, repo)
+	if !saveAPISetting(w, conf) {
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, repoToAPI(repo))
+}
+
+func apiDeleteRepo(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	repoName := apiRepoName(r)
+	if repoName == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_repo", "Invalid Repo Name Provided")
+		return
+	}
+
+	if success, _ := deleteRepo(conf, &Repo{Repo: repoName}); !success {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Repository is not being tracked")
+		return
+	}
+	if !saveAPISetting(w, conf) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Organisations
+
+func orgToAPI(org *Organisation) *apiOrg {
+	return &apiOrg{Name: org.Name, Type: org.Type, Channels: nonNilStrings(org.Channels)}
+}
+
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func findOrg(conf *Setting, orgName string) *Organisation {
+	for _, org := range conf.Orgs {
+		if org.Name == orgName {
+			return org
+		}
+	}
+	return nil
+}
+
+func apiListOrgs(w http.ResponseWriter, _ *http.Request, conf *Setting) {
+	orgs := make([]*apiOrg, 0, len(conf.Orgs))
+	for _, org := range conf.Orgs {
+		orgs = append(orgs, orgToAPI(org))
+	}
+	writeJSON(w, http.StatusOK, orgs)
+}
+
+func apiShowOrg(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	org := findOrg(conf, validateOrgName(mux.Vars(r)["name"]))
+	if org == nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "User/Org is not being tracked")
+		return
+	}
+	writeJSON(w, http.StatusOK, orgToAPI(org))
+}
+
+func apiCreateOrg(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	in := &apiOrg{}
+	if !decodeAPIBody(w, r, in) {
+		return
+	}
+	apiSaveOrg(w, conf, validateOrgName(in.Name), in)
+}
+
+// the org type is always fetched from the remote, only channels can be updated
+func apiUpdateOrg(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	in := &apiOrg{}
+	if !decodeAPIBody(w, r, in) {
+		return
+	}
+	apiSaveOrg(w, conf, validateOrgName(mux.Vars(r)["name"]), in)
+}
+
+func apiSaveOrg(w http.ResponseWriter, conf *Setting, orgName string, in *apiOrg) {
+	var provider = conf.Auth.Provider
+
+	if orgName == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_org", "Invalid Org Name Provided")
+		return
+	}
+
+	orgType, present := getRemoteOrgType(provider, conf.Auth.Token, orgName)
+	if !present {
+		writeAPIError(w, http.StatusUnprocessableEntity, "remote_not_found", fmt.Sprintf("Org/User Name Not Found with %s", provider))
+		return
+	}
+
+	org := &Organisation{
+		orgName,
+		orgType,
+		provider,
+		conf.cleanRoutes(in.Channels),
+	}
+
+	created := upsertOrg(conf, org)
+	if !saveAPISetting(w, conf) {
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, orgToAPI(org))
+}
+
+func apiDeleteOrg(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	orgName := validateOrgName(mux.Vars(r)["name"])
+	if orgName == "" {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_org", "Invalid Org Name Provided")
+		return
+	}
+
+	if success, _ := deleteOrg(conf, &Organisation{Name: orgName}); !success {
+		writeAPIError(w, http.StatusNotFound, "not_found", "User/Org is not being tracked")
+		return
+	}
+	if !saveAPISetting(w, conf) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Notification settings
+
+func notificationToAPI(u *UserNotification) *apiNotification {
+	n := &apiNotification{
+		Email:        u.Email,
+		Name:         u.Name,
+		Disabled:     u.Disabled,
+		TimeZone:     u.TimeZone,
+		TimeZoneName: u.TimeZoneName,
+		Hour:         u.Hour,
+		WeekDay:      u.WeekDay,
+		PerRepoEmail: u.PerRepoEmails,
+		Channels:     channelsToAPI(u.Channels),
+		PendingEmail: u.PendingEmail,
+	}
+	for _, e := range u.InvalidEmails {
+		n.InvalidEmails = append(n.InvalidEmails, e.Address)
+	}
+	return n
+}
+
+func channelsToAPI(channels []*NotificationChannel) []*apiChannel {
+	list := make([]*apiChannel, 0, len(channels))
+	for _, ch := range channels {
+		list = append(list, &apiChannel{
+			Name:    ch.Name,
+			Type:    ch.Type,
+			Target:  ch.Target,
+			Enabled: ch.Enabled,
+			Repos:   nonNilStrings(ch.Repos),
+
+			Recipient: ch.Recipient,
+			Format:    ch.Format,
+			HasToken:  ch.HasToken(),
+			HasSecret: ch.HasSecret(),
+			Template:  ch.Template,
+			Priority:  ch.Priority,
+			Tags:      ch.Tags,
+			Pending:   ch.Pending,
+		})
+	}
+	return list
+}
+
+func apiShowNotification(w http.ResponseWriter, _ *http.Request, conf *Setting) {
+	writeJSON(w, http.StatusOK, notificationToAPI(conf.User))
+}
+
+// fields missing in the request body retain their current values
+func apiUpdateNotification(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	in := notificationToAPI(conf.User)
+	if !decodeAPIBody(w, r, in) {
+		return
+	}
+
+	email := strings.TrimSpace(in.Email)
+	if email != "" {
+		e, err := mail.ParseAddress(email)
+		if err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid_email", "email address provided is invalid format")
+			return
+		}
+		email = e.Address
+	}
+
+	tzName := in.TimeZoneName
+	if tzName != "" {
+		if err := cleanTzName(tzName); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid_timezone", err.Error())
+			return
+		}
+	}
+
+	if len(in.Channels) > maxChannels {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_channel", fmt.Sprintf("Only %d channels are allowed", maxChannels))
+		return
+	}
+	channels := make([]*NotificationChannel, 0, len(in.Channels))
+	for _, c := range in.Channels {
+		if c == nil {
+			continue
+		}
+		ch := &NotificationChannel{
+			Name:    c.Name,
+			Type:    c.Type,
+			Target:  strings.TrimSpace(c.Target),
+			Enabled: c.Enabled,
+			Repos:   c.Repos,
+
+			Recipient: strings.TrimSpace(c.Recipient),
+			Token:     strings.TrimSpace(c.Token),
+			Format:    c.Format,
+			Secret:    strings.TrimSpace(c.Secret),
+			Template:  c.Template,
+			Priority:  strings.TrimSpace(c.Priority),
+			Tags:      c.Tags,
+		}
+		ch.keepSecrets(conf.User)
+		if err := ch.validate(); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, "invalid_channel", err.Error())
+			return
+		}
+		channels = append(channels, ch)
+	}
+
+	if email == "" {
+		conf.User.Email = ""
+		conf.User.PendingEmail = ""
+	} else if _, err := conf.changeEmail(email); err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "confirmation_not_sent", err.Error())
+		return
+	}
+	conf.User.Name = in.Name
+	if len(conf.User.Name) > 100 {
+		conf.User.Name = conf.User.Name[0:100]
+	}
+	conf.User.Disabled = in.Disabled
+	conf.User.PerRepoEmails = in.PerRepoEmail
+	conf.User.TimeZone = cleanTz(in.TimeZone)
+	if tzName == "" {
+		tzName = tzNameForOffset(conf.User.TimeZone)
+	}
+	conf.User.TimeZoneName = tzName
+	conf.User.Hour = cleanHour(strings.Split(in.Hour, ","))
+	conf.User.WeekDay = cleanWeekday(strings.Split(in.WeekDay, ","))
+	if _, err := conf.setChannels(cleanChannels(channels)); err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "confirmation_not_sent", err.Error())
+		return
+	}
+	conf.pruneInvalidEmails()
+
+	if !saveAPISetting(w, conf) {
+		return
+	}
+	upsertCronEntry(conf)
+
+	writeJSON(w, http.StatusOK, notificationToAPI(conf.User))
+}
+
+// Diff history
+
+func apiListDiffs(w http.ResponseWriter, _ *http.Request, conf *Setting) {
+	files := (&gnDiffDatum{}).ListUserChanges(conf)
+	diffs := make([]*apiDiffSummary, 0, len(files))
+	for _, f := range files {
+		diffs = append(diffs, &apiDiffSummary{
+			ID:        fmt.Sprintf("%d", f.Reference),
+			Display:   f.Display,
+			CreatedAt: time.Unix(f.Reference, 0).UTC(),
+		})
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+func apiShowDiff(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	diffs := &gnDiffDatum{}
+	if err := diffs.load(mux.Vars(r)["id"], conf); err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Diff not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+// Trigger a run
+
+// runs are processed in the background. pass ?save=false to not persist the fetched information
+func apiRun(w http.ResponseWriter, r *http.Request, conf *Setting) {
+	if !hasUserNotificationSet(conf) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "notification_not_set", "Email or Webhook is not set. Update /api/v1/notifications to set")
+		return
+	}
+
+	isSaveFalse := isSaveSetToFalse(r.URL.Query())
+	go cronJob{conf.Auth.getConfigFile(), !isSaveFalse}.Run()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
@@ -0,0 +1,226 @@
+package gitnotify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sairam/kinli"
+)
+
+// apiTokenPrefix is prepended to every personal API token so they are easy to spot in logs/scanners
+const apiTokenPrefix = "gn1"
+
+// APIToken is a personal access token used against /api/v1
+// Only the sha256 of the secret is persisted, the secret is shown once on creation
+type APIToken struct {
+	ID        string `yaml:"id"`
+	Name      string `yaml:"name"`
+	Hash      string `yaml:"hash"`
+	CreatedAt int64  `yaml:"created_at"`
+}
+
+// Created is used by the view to display the creation time
+func (t *APIToken) Created() string {
+	return time.Unix(t.CreatedAt, 0).UTC().Format("02 Jan 2006 15:04 MST")
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashAPISecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIToken creates a token for the user and returns the plain text version to be shown once
+// format is gn1.<base64 of provider/username>.<secret>
+func newAPIToken(conf *Setting, name string) (string, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomHex(20)
+	if err != nil {
+		return "", err
+	}
+
+	conf.APITokens = append(conf.APITokens, &APIToken{
+		ID:        id,
+		Name:      name,
+		Hash:      hashAPISecret(secret),
+		CreatedAt: time.Now().Unix(),
+	})
+
+	owner := base64.RawURLEncoding.EncodeToString([]byte(conf.Auth.UserInfo()))
+	return strings.Join([]string{apiTokenPrefix, owner, secret}, "."), nil
+}
+
+func deleteAPIToken(conf *Setting, id string) bool {
+	var tokens []*APIToken
+	isProcessed := false
+	for _, t := range conf.APITokens {
+		if t.ID == id {
+			isProcessed = true
+		} else {
+			tokens = append(tokens, t)
+		}
+	}
+	conf.APITokens = tokens
+	return isProcessed
+}
+
+type invalidAPIToken struct{}
+
+func (invalidAPIToken) Error() string {
+	return "API token is missing or invalid"
+}
+
+// settingForAPIToken finds the owner of the token and loads their settings
+func settingForAPIToken(token string) (*Setting, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != apiTokenPrefix {
+		return nil, &invalidAPIToken{}
+	}
+
+	conf, err := settingForOwner(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashAPISecret(parts[2])
+	for _, t := range conf.APITokens {
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) == 1 {
+			return conf, nil
+		}
+	}
+	return nil, &invalidAPIToken{}
+}
+
+// apiTokenConfigFile is the settings file of the owner of the token, or empty when the token is invalid
+func apiTokenConfigFile(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != apiTokenPrefix {
+		return ""
+	}
+	auth, err := tokenOwner(parts[1])
+	if err != nil {
+		return ""
+	}
+	return auth.getConfigFile()
+}
+
+// tokenOwner decodes the base64 encoded provider/username present in the tokens
+func tokenOwner(encoded string) (*Authentication, error) {
+	owner, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, &invalidAPIToken{}
+	}
+	providerUser := strings.SplitN(string(owner), "/", 2)
+	if len(providerUser) != 2 || config.Providers[providerUser[0]] == "" || !isSafePathName(providerUser[1]) {
+		return nil, &invalidAPIToken{}
+	}
+	return &Authentication{Provider: providerUser[0], UserName: providerUser[1]}, nil
+}
+
+// settingForOwner loads the settings of the base64 encoded provider/username present in the tokens
+func settingForOwner(encoded string) (*Setting, error) {
+	auth, err := tokenOwner(encoded)
+	if err != nil {
+		return nil, err
+	}
+	conf := new(Setting)
+	if err := conf.load(auth.getConfigFile()); err != nil || conf.Auth == nil {
+		return nil, &invalidAPIToken{}
+	}
+	return conf, nil
+}
+
+// isSafePathName avoids usernames being used to traverse the data directory
+func isSafePathName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\")
+}
+
+// apiTokenFromRequest reads "Authorization: Bearer <token>" or "Authorization: token <token>"
+func apiTokenFromRequest(r *http.Request) string {
+	header := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(header) != 2 {
+		return ""
+	}
+	scheme := strings.ToLower(header[0])
+	if scheme != "bearer" && scheme != "token" {
+		return ""
+	}
+	return strings.TrimSpace(header[1])
+}
+
+func apiTokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	defer lockSetting(configFile)()
+	conf := new(Setting)
+	conf.load(configFile)
+
+	r.ParseForm()
+	name := strings.TrimSpace(getFirstValue(r.Form, "tokenName"))
+	if name == "" {
+		name = "API Token"
+	}
+	if len(name) > 50 {
+		name = name[0:50]
+	}
+
+	token, err := newAPIToken(conf, name)
+	if err != nil {
+		hc.AddFlash("Error creating token " + err.Error())
+	} else if err = conf.save(configFile); err != nil {
+		hc.AddFlash("Error saving configuration " + err.Error())
+	} else {
+		hc.AddFlash(fmt.Sprintf("Created token '%s'. Copy it now, it will not be shown again: <code>%s</code>", html.EscapeString(name), token))
+	}
+
+	http.Redirect(w, r, "/user#api-tokens", 302)
+}
+
+func apiTokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	defer lockSetting(configFile)()
+	conf := new(Setting)
+	conf.load(configFile)
+
+	r.ParseForm()
+	if !deleteAPIToken(conf, getFirstValue(r.Form, "tokenID")) {
+		hc.AddFlash("Token not found")
+	} else if err := conf.save(configFile); err != nil {
+		hc.AddFlash("Error saving configuration " + err.Error())
+	} else {
+		hc.AddFlash("Token revoked")
+	}
+
+	http.Redirect(w, r, "/user#api-tokens", 302)
+}
@@ -0,0 +1,166 @@
+package gitnotify
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIToken(t *testing.T) {
+	defer withDataDir(t)()
+	config.Providers = map[string]string{"github": "GitHub"}
+	conf, _, _ := testDelivery("")
+
+	token, err := newAPIToken(conf, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := newAPIToken(conf, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleteAPIToken(conf, conf.APITokens[1].ID) || deleteAPIToken(conf, "missing") {
+		t.Fatal("expected only the saved token to be deleted")
+	}
+	saveTestSettings(t, conf)
+
+	owner := base64.RawURLEncoding.EncodeToString([]byte("github/alice"))
+	traversal := base64.RawURLEncoding.EncodeToString([]byte("github/../alice"))
+	unknown := base64.RawURLEncoding.EncodeToString([]byte("gitlab/alice"))
+	secret := token[strings.LastIndex(token, ".")+1:]
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"token", token, true},
+		{"revoked", revoked, false},
+		{"wrong secret", apiTokenPrefix + "." + owner + ".0123456789abcdef", false},
+		{"the hash as the secret", apiTokenPrefix + "." + owner + "." + hashAPISecret(secret), false},
+		{"other prefix", "gn2." + owner + "." + secret, false},
+		{"missing secret", apiTokenPrefix + "." + owner, false},
+		{"path traversal", apiTokenPrefix + "." + traversal + "." + secret, false},
+		{"unknown provider", apiTokenPrefix + "." + unknown + "." + secret, false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		got, err := settingForAPIToken(tt.token)
+		if tt.valid && (err != nil || got.Auth.UserName != "alice") {
+			t.Errorf("%s: expected the settings of alice, got %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the token to be rejected", tt.name)
+		}
+	}
+}
+
+func TestAPITokenFromRequest(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+	}{
+		{"Bearer gn1.a.b", "gn1.a.b"},
+		{"token gn1.a.b", "gn1.a.b"},
+		{"bearer  gn1.a.b ", "gn1.a.b"},
+		{"Basic YWxpY2U6c2VjcmV0", ""},
+		{"gn1.a.b", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/repos", nil)
+		r.Header.Set("Authorization", tt.header)
+		if got := apiTokenFromRequest(r); got != tt.token {
+			t.Errorf("%q: expected %q, got %q", tt.header, tt.token, got)
+		}
+	}
+}
+
+func TestWithAPIAuth(t *testing.T) {
+	defer withDataDir(t)()
+	config.Providers = map[string]string{"github": "GitHub"}
+	conf, _, _ := testDelivery("")
+	token, err := newAPIToken(conf, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestSettings(t, conf)
+
+	handler := withAPIAuth(apiListRepos)
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"authorized", "Bearer " + token, http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"invalid", "Bearer " + token + "0", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/repos", nil)
+		r.Header.Set("Authorization", tt.header)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s: expected a JSON response, got %s", tt.name, ct)
+		}
+	}
+}
+
+func TestIsSafePathName(t *testing.T) {
+	tests := []struct {
+		name string
+		safe bool
+	}{
+		{"alice", true},
+		{"alice.bob", true},
+		{"", false},
+		{"..", false},
+		{".hidden", false},
+		{"a/b", false},
+		{`a\b`, false},
+	}
+	for _, tt := range tests {
+		if got := isSafePathName(tt.name); got != tt.safe {
+			t.Errorf("%q: expected %v, got %v", tt.name, tt.safe, got)
+		}
+	}
+}
+
+func TestWithAPIAuthLocksSetting(t *testing.T) {
+	defer withDataDir(t)()
+	config.Providers = map[string]string{"github": "GitHub"}
+	conf, _, _ := testDelivery("")
+	token, err := newAPIToken(conf, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestSettings(t, conf)
+	if got := apiTokenConfigFile(token); got != conf.Auth.getConfigFile() {
+		t.Fatalf("expected the settings file of alice, got %q", got)
+	}
+
+	unlock := lockSetting(conf.Auth.getConfigFile())
+	done := make(chan int)
+	go func() {
+		r := httptest.NewRequest("POST", "/api/v1/orgs", strings.NewReader(`{"name": ""}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		withAPIAuth(apiCreateOrg)(w, r)
+		done <- w.Code
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the change to wait for the lock of the settings")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if code := <-done; code == http.StatusUnauthorized {
+		t.Errorf("expected the request to be authorized once the settings were unlocked, got %d", code)
+	}
+}
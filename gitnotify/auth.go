@@ -33,6 +33,7 @@ func (userInfo *Authentication) UserInfo() string {
 func (userInfo *Authentication) save() {
 	conf := new(Setting)
 	os.MkdirAll(userInfo.getConfigDir(), 0700)
+	defer lockSetting(userInfo.getConfigFile())()
 	conf.load(userInfo.getConfigFile())
 	conf.Auth = userInfo
 	conf.save(userInfo.getConfigFile())
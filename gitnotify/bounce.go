@@ -0,0 +1,535 @@
+package gitnotify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Emails to addresses which bounce permanently or complain are stopped until the user changes the address
+//
+//	POST /bounces/ses receives the SES bounce and complaint notifications of the SNS topics in config.SNSTopicARNs
+//	"gitnotify bounce-mail" reads the delivery status notifications (RFC 3464) sent to fromEmail, from a maildir or stdin
+//
+// The address is recorded in the InvalidEmails of every user using it, and /user asks them to fix it
+// Anyone can send a mail to fromEmail, so a DSN is processed only when the headers of the email it returns have
+// a Message-ID signed by composeEmail, and only the recipients of that email are marked
+//
+//	Message-ID: <local.SIGNATURE@host>
+//
+// SIGNATURE is the base64 of the HMAC-SHA256 of the local part and the recipients using config.UnsubscribeSecret
+const (
+	invalidEmailBounce    = "bounce"
+	invalidEmailComplaint = "complaint"
+
+	// signed along with the local part and the recipients so that the signature is not valid as another token
+	messageIDPurpose = "message-id\n"
+
+	snsMaxMessageSize = 256 * 1024
+	snsFetchTimeout   = 10 * time.Second
+)
+
+// certificates and subscription urls of SNS are only fetched from these hosts
+var snsHostRegex = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// InvalidEmail is an address which stopped receiving emails after a bounce or a complaint
+type InvalidEmail struct {
+	Address string    `yaml:"address"`
+	Kind    string    `yaml:"kind"` // bounce or complaint
+	Reason  string    `yaml:"reason,omitempty"`
+	At      time.Time `yaml:"at"`
+}
+
+// Description is used by the view to say why the emails are stopped
+func (e *InvalidEmail) Description() string {
+	if e.Kind == invalidEmailComplaint {
+		return "were marked as spam"
+	}
+	if e.Reason == "" {
+		return "bounced"
+	}
+	return "bounced with " + e.Reason
+}
+
+func (u *UserNotification) isEmailInvalid(address string) bool {
+	for _, e := range u.InvalidEmails {
+		if strings.EqualFold(e.Address, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsEmail(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// emailAddresses are the primary email and the targets of the email channels
+func (c *Setting) emailAddresses() []string {
+	addresses := []string{c.usersEmail()}
+	for _, ch := range c.User.Channels {
+		if ch.Type == emailChannelType {
+			addresses = append(addresses, ch.Target)
+		}
+	}
+	return addresses
+}
+
+// pruneInvalidEmails forgets the invalid addresses which were replaced by the user
+func (c *Setting) pruneInvalidEmails() {
+	addresses := c.emailAddresses()
+	var invalid []*InvalidEmail
+	for _, e := range c.User.InvalidEmails {
+		for _, address := range addresses {
+			if strings.EqualFold(e.Address, address) {
+				invalid = append(invalid, e)
+				break
+			}
+		}
+	}
+	c.User.InvalidEmails = invalid
+}
+
+// markEmailInvalid records the address as invalid for all the users using it. Returns the number of users updated
+func markEmailInvalid(invalid *InvalidEmail) (int, error) {
+	files, err := filepath.Glob(filepath.Join(config.DataDir, "*", "*", config.SettingsFile))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, file := range files {
+		marked, err := markEmailInvalidIn(file, invalid)
+		if err != nil {
+			return count, err
+		}
+		if marked {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// markEmailInvalidIn records the invalid address in the settings file when the user sends to it
+// The file is locked so that a cron run saving its fetched information does not overwrite the mark
+func markEmailInvalidIn(file string, invalid *InvalidEmail) (bool, error) {
+	unlock := lockSetting(file)
+	defer unlock()
+
+	conf := new(Setting)
+	if err := conf.load(file); err != nil || conf.Auth == nil || conf.User == nil || conf.User.isEmailInvalid(invalid.Address) {
+		return false, nil
+	}
+	for _, address := range conf.emailAddresses() {
+		if !strings.EqualFold(address, invalid.Address) {
+			continue
+		}
+		conf.User.InvalidEmails = append(conf.User.InvalidEmails, invalid)
+		if err := conf.save(file); err != nil {
+			return false, err
+		}
+		log.Printf("Stopped emails of %s to %s after a %s", conf.Auth.UserInfo(), invalid.Address, invalid.Kind)
+		return true, nil
+	}
+	return false, nil
+}
+
+func messageIDSignature(local string, to []string) string {
+	addresses := make([]string, len(to))
+	for i, address := range to {
+		addresses[i] = strings.ToLower(address)
+	}
+	return unsubscribeSignature(messageIDPurpose + local + "\n" + strings.Join(addresses, ","))
+}
+
+// signMessageID adds the signature to the local part of the Message-ID of an email sent to the addresses
+// The Message-ID is unchanged when the secret is not configured
+func signMessageID(id string, to []string) string {
+	at := strings.LastIndex(id, "@")
+	if config.UnsubscribeSecret == "" || !strings.HasPrefix(id, "<") || at < 0 {
+		return id
+	}
+	local := id[1:at]
+	return "<" + local + "." + messageIDSignature(local, to) + id[at:]
+}
+
+// verifyMessageID checks that the Message-ID was signed by signMessageID for the addresses
+func verifyMessageID(id string, to []string) bool {
+	id = strings.TrimSpace(id)
+	at := strings.LastIndex(id, "@")
+	if config.UnsubscribeSecret == "" || !strings.HasPrefix(id, "<") || at < 0 {
+		return false
+	}
+	dot := strings.LastIndex(id[:at], ".")
+	if dot < 1 {
+		return false
+	}
+	return hmac.Equal([]byte(messageIDSignature(id[1:dot], to)), []byte(id[dot+1:at]))
+}
+
+// sentRecipients returns the recipients of the email returned by a DSN when it was sent by GitNotify
+func sentRecipients(original textproto.MIMEHeader) ([]string, error) {
+	if original == nil {
+		return nil, errors.New("headers of the returned email are missing")
+	}
+	addresses, err := mail.ParseAddressList(original.Get("To"))
+	if err != nil {
+		return nil, fmt.Errorf("recipients of the returned email are invalid: %s", err)
+	}
+	to := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		to = append(to, address.Address)
+	}
+	if !verifyMessageID(original.Get("Message-ID"), to) {
+		return nil, errors.New("returned email was not sent by GitNotify")
+	}
+	return to, nil
+}
+
+// snsMessage is the envelope of the messages posted by SNS to http subscriptions
+// https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+}
+
+// sesNotification is the part of the SES notification used to find the invalid addresses
+// https://docs.aws.amazon.com/ses/latest/dg/notification-contents.html
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"` // set instead of notificationType by the event publishing of configuration sets
+	Bounce           *struct {
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+// snsCertificates caches the signing certificates by url
+var snsCertificates = struct {
+	sync.Mutex
+	keys map[string]*rsa.PublicKey
+}{keys: make(map[string]*rsa.PublicKey)}
+
+var snsClient = &http.Client{Timeout: snsFetchTimeout}
+
+func isSNSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && snsHostRegex.MatchString(u.Host)
+}
+
+// stringToSign has the fields signed by SNS in alphabetical order
+func (m *snsMessage) stringToSign() string {
+	fields := [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", m.Timestamp}, [2]string{"TopicArn", m.TopicArn})
+	} else {
+		fields = append(fields, [2]string{"SubscribeURL", m.SubscribeURL}, [2]string{"Timestamp", m.Timestamp},
+			[2]string{"Token", m.Token}, [2]string{"TopicArn", m.TopicArn})
+	}
+	fields = append(fields, [2]string{"Type", m.Type})
+
+	b := &bytes.Buffer{}
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return b.String()
+}
+
+// verify checks the signature of the message with the certificate of SNS
+func (m *snsMessage) verify() error {
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return err
+	}
+	key, err := snsSigningKey(m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	switch m.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(m.stringToSign()))
+		return rsa.VerifyPKCS1v15(key, crypto.SHA1, sum[:], signature)
+	case "2":
+		sum := sha256.Sum256([]byte(m.stringToSign()))
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature)
+	}
+	return fmt.Errorf("unsupported signature version %q", m.SignatureVersion)
+}
+
+func snsSigningKey(certURL string) (*rsa.PublicKey, error) {
+	if !isSNSURL(certURL) {
+		return nil, fmt.Errorf("signing certificate %s is not from SNS", certURL)
+	}
+	snsCertificates.Lock()
+	key := snsCertificates.keys[certURL]
+	snsCertificates.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	resp, err := snsClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, snsMaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("signing certificate does not have a RSA key")
+	}
+
+	snsCertificates.Lock()
+	snsCertificates.keys[certURL] = key
+	snsCertificates.Unlock()
+	return key, nil
+}
+
+// invalidEmails returns the permanent bounces and the complaints of the notification
+func (n *sesNotification) invalidEmails(at time.Time) []*InvalidEmail {
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	var invalid []*InvalidEmail
+	switch {
+	case kind == "Bounce" && n.Bounce != nil && n.Bounce.BounceType == "Permanent":
+		for _, r := range n.Bounce.BouncedRecipients {
+			invalid = append(invalid, &InvalidEmail{Address: r.EmailAddress, Kind: invalidEmailBounce, Reason: r.DiagnosticCode, At: at})
+		}
+	case kind == "Complaint" && n.Complaint != nil:
+		for _, r := range n.Complaint.ComplainedRecipients {
+			invalid = append(invalid, &InvalidEmail{Address: r.EmailAddress, Kind: invalidEmailComplaint, Reason: n.Complaint.ComplaintFeedbackType, At: at})
+		}
+	}
+	return invalid
+}
+
+// sesBounceHandler handles the messages of the SNS topics which receive the SES notifications
+func sesBounceHandler(w http.ResponseWriter, r *http.Request) {
+	if len(config.SNSTopicARNs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	m := &snsMessage{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, snsMaxMessageSize)).Decode(m); err != nil {
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+	if !StringIn(config.SNSTopicARNs, m.TopicArn) {
+		http.Error(w, "unknown topic", http.StatusForbidden)
+		return
+	}
+	if err := m.verify(); err != nil {
+		log.Printf("Invalid signature of SNS message %s: %s", m.MessageID, err)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	switch m.Type {
+	case "SubscriptionConfirmation":
+		if !isSNSURL(m.SubscribeURL) {
+			http.Error(w, "invalid subscribe url", http.StatusBadRequest)
+			return
+		}
+		resp, err := snsClient.Get(m.SubscribeURL)
+		if err != nil {
+			log.Printf("Error confirming the subscription to %s: %s", m.TopicArn, err)
+			http.Error(w, "subscription failed", http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+		log.Printf("Subscribed to %s", m.TopicArn)
+	case "Notification":
+		n := &sesNotification{}
+		if err := json.Unmarshal([]byte(m.Message), n); err != nil {
+			http.Error(w, "invalid notification", http.StatusBadRequest)
+			return
+		}
+		for _, invalid := range n.invalidEmails(time.Now().UTC()) {
+			if _, err := markEmailInvalid(invalid); err != nil {
+				log.Printf("Error marking %s invalid: %s", invalid.Address, err)
+			}
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// parseDSN returns the recipients which failed permanently in a delivery status notification
+func parseDSN(r io.Reader) ([]*InvalidEmail, textproto.MIMEHeader, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, nil, errors.New("not a delivery status notification")
+	}
+
+	at := time.Now().UTC()
+	if date, err := msg.Header.Date(); err == nil {
+		at = date.UTC()
+	}
+	var invalid []*InvalidEmail
+	var original textproto.MIMEHeader
+	found := false
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); contentType {
+		case "message/delivery-status":
+			if invalid, err = parseDeliveryStatus(part, at); err != nil {
+				return nil, nil, err
+			}
+			found = true
+		case "message/rfc822", "text/rfc822-headers":
+			// the returned email or its headers
+			if original, err = textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader(); err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+		}
+	}
+	if !found {
+		return nil, nil, errors.New("delivery status is missing")
+	}
+	return invalid, original, nil
+}
+
+// parseDeliveryStatus reads the per-message fields followed by the fields of each recipient
+func parseDeliveryStatus(r io.Reader, at time.Time) ([]*InvalidEmail, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	if _, err := tp.ReadMIMEHeader(); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var invalid []*InvalidEmail
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if e := dsnRecipient(fields, at); e != nil {
+			invalid = append(invalid, e)
+		}
+		if err == io.EOF {
+			return invalid, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// dsnRecipient returns the recipient when the delivery failed with a permanent 5.x.x status
+func dsnRecipient(fields textproto.MIMEHeader, at time.Time) *InvalidEmail {
+	status := fields.Get("Status")
+	if !strings.EqualFold(fields.Get("Action"), "failed") || !strings.HasPrefix(status, "5") {
+		return nil
+	}
+	recipient := fields.Get("Final-Recipient")
+	if recipient == "" {
+		recipient = fields.Get("Original-Recipient")
+	}
+	// address-type; address
+	if i := strings.Index(recipient, ";"); i >= 0 {
+		recipient = recipient[i+1:]
+	}
+	recipient = strings.Trim(strings.TrimSpace(recipient), "<>")
+	if recipient == "" {
+		return nil
+	}
+
+	reason := status
+	if diagnostic := fields.Get("Diagnostic-Code"); diagnostic != "" {
+		reason = strings.TrimSpace(diagnostic[strings.Index(diagnostic, ";")+1:])
+	}
+	return &InvalidEmail{Address: recipient, Kind: invalidEmailBounce, Reason: reason, At: at}
+}
+
+// processBounceMail marks the failed recipients of a delivery status notification as invalid
+func processBounceMail(r io.Reader) error {
+	invalid, original, err := parseDSN(r)
+	if err != nil {
+		return err
+	}
+	to, err := sentRecipients(original)
+	if err != nil {
+		return err
+	}
+	for _, e := range invalid {
+		if !containsEmail(to, e.Address) {
+			log.Printf("Ignoring the bounce of %s which was not a recipient of the returned email", e.Address)
+			continue
+		}
+		if _, err = markEmailInvalid(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package gitnotify
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDSN is a delivery status notification of a failed recipient returning the headers of the email
+func testDSN(recipient, messageID, to string) string {
+	return strings.Replace(fmt.Sprintf(`From: MAILER-DAEMON@example.com
+To: notify@example.com
+Date: Mon, 02 Jan 2006 15:04:05 +0000
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b"
+
+--b
+Content-Type: text/plain
+
+The mail could not be delivered.
+--b
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+
+Final-Recipient: rfc822; %s
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 user unknown
+--b
+Content-Type: text/rfc822-headers
+
+Message-ID: %s
+To: %s
+Subject: [GitNotify] rails/rails
+--b--
+`, recipient, messageID, to), "\n", "\r\n", -1)
+}
+
+func TestMessageIDSignature(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{UnsubscribeSecret: "unsubscribe-secret"}
+
+	signed := signMessageID("<1.abc.rails@example.com>", []string{"Alice@Example.com"})
+	if signed == "<1.abc.rails@example.com>" || !strings.HasPrefix(signed, "<1.abc.rails.") {
+		t.Fatalf("expected the local part to be signed, got %s", signed)
+	}
+	tests := []struct {
+		name  string
+		id    string
+		to    []string
+		valid bool
+	}{
+		{"signed", signed, []string{"alice@example.com"}, true},
+		{"other recipient", signed, []string{"bob@example.com"}, false},
+		{"added recipient", signed, []string{"alice@example.com", "bob@example.com"}, false},
+		{"changed local part", strings.Replace(signed, "<1.", "<2.", 1), []string{"alice@example.com"}, false},
+		{"unsigned", "<1.abc.rails@example.com>", []string{"alice@example.com"}, false},
+		{"not a message id", "alice@example.com", []string{"alice@example.com"}, false},
+	}
+	for _, tt := range tests {
+		if got := verifyMessageID(tt.id, tt.to); got != tt.valid {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.valid, got)
+		}
+	}
+
+	config.UnsubscribeSecret = ""
+	if got := signMessageID("<1@example.com>", nil); got != "<1@example.com>" {
+		t.Errorf("expected the Message-ID to be unchanged without a secret, got %s", got)
+	}
+	if verifyMessageID(signed, []string{"alice@example.com"}) {
+		t.Error("expected nothing to be verified without a secret")
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	invalid, original, err := parseDSN(strings.NewReader(testDSN("alice@example.com", "<1@example.com>", "alice@example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalid) != 1 || invalid[0].Address != "alice@example.com" || invalid[0].Kind != invalidEmailBounce {
+		t.Fatalf("unexpected recipients %+v", invalid)
+	}
+	if invalid[0].Reason != "550 5.1.1 user unknown" {
+		t.Errorf("unexpected reason %q", invalid[0].Reason)
+	}
+	if original.Get("Message-ID") != "<1@example.com>" {
+		t.Errorf("expected the headers of the returned email, got %v", original)
+	}
+
+	if _, _, err = parseDSN(strings.NewReader("From: alice@example.com\r\nSubject: hi\r\n\r\nbody\r\n")); err == nil {
+		t.Error("expected a plain mail not to be a delivery status notification")
+	}
+}
+
+func TestProcessBounceMail(t *testing.T) {
+	defer withDataDir(t)()
+	config.UnsubscribeSecret = "unsubscribe-secret"
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{Email: "alice@example.com"}}
+	saveTestSettings(t, conf, &NotificationChannel{Name: "team", Type: emailChannelType, Target: "team@example.com", Enabled: true})
+	signed := signMessageID("<1@example.com>", []string{"alice@example.com"})
+
+	tests := []struct {
+		name      string
+		mail      string
+		ok        bool
+		recipient string
+	}{
+		{"forged", testDSN("alice@example.com", "<1@example.com>", "alice@example.com"), false, ""},
+		{"other recipient", testDSN("team@example.com", signed, "alice@example.com"), true, ""},
+		{"bounce", testDSN("alice@example.com", signed, "alice@example.com"), true, "alice@example.com"},
+	}
+	for _, tt := range tests {
+		err := processBounceMail(strings.NewReader(tt.mail))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		latest := new(Setting)
+		if err = latest.load(conf.Auth.getConfigFile()); err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if len(latest.User.InvalidEmails) > 0 {
+			got = latest.User.InvalidEmails[0].Address
+		}
+		if got != tt.recipient {
+			t.Errorf("%s: expected %q to be invalid, got %q", tt.name, tt.recipient, got)
+		}
+	}
+}
+
+func TestSaveFetchedInfoKeepsInvalidEmails(t *testing.T) {
+	defer withDataDir(t)()
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{Email: "alice@example.com"}}
+	saveTestSettings(t, conf)
+	file := conf.Auth.getConfigFile()
+
+	// a cron run loaded the settings before the address bounced
+	run := new(Setting)
+	if err := run.load(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := markEmailInvalid(&InvalidEmail{Address: "alice@example.com", Kind: invalidEmailBounce, At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	run.Info = map[string]*Information{"rails/rails": {}}
+	if err := saveFetchedInfo(run, file); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := new(Setting)
+	if err := latest.load(file); err != nil {
+		t.Fatal(err)
+	}
+	if !latest.User.isEmailInvalid("alice@example.com") {
+		t.Error("expected the run not to overwrite the invalid address")
+	}
+	if latest.Info["rails/rails"] == nil {
+		t.Error("expected the fetched information to be saved")
+	}
+}
+
+func TestSNSStringToSign(t *testing.T) {
+	tests := []struct {
+		name    string
+		message *snsMessage
+		want    string
+	}{
+		{
+			"notification",
+			&snsMessage{Type: "Notification", MessageID: "1", Message: "m", Timestamp: "t", TopicArn: "arn"},
+			"Message\nm\nMessageId\n1\nTimestamp\nt\nTopicArn\narn\nType\nNotification\n",
+		},
+		{
+			"notification with subject",
+			&snsMessage{Type: "Notification", MessageID: "1", Message: "m", Subject: "s", Timestamp: "t", TopicArn: "arn"},
+			"Message\nm\nMessageId\n1\nSubject\ns\nTimestamp\nt\nTopicArn\narn\nType\nNotification\n",
+		},
+		{
+			"subscription",
+			&snsMessage{Type: "SubscriptionConfirmation", MessageID: "1", Message: "m", SubscribeURL: "u", Timestamp: "t", Token: "k", TopicArn: "arn"},
+			"Message\nm\nMessageId\n1\nSubscribeURL\nu\nTimestamp\nt\nToken\nk\nTopicArn\narn\nType\nSubscriptionConfirmation\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.message.stringToSign(); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
@@ -35,6 +35,14 @@ type diffData struct {
 	Changes    []link `json:"changes"`
 }
 
+// heading is the title of the new branches/tags, or the name of the org whose repos are new
+func (d diffData) heading() string {
+	if title := strings.TrimSpace(d.Title.Title); title != "" {
+		return title
+	}
+	return d.Title.Text
+}
+
 type link struct {
 	Text  string `json:"text"`
 	Href  string `json:"href"`
@@ -0,0 +1,344 @@
+package gitnotify
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// maximum number of notification channels a user can configure
+const maxChannels = 10
+
+const (
+	// emailChannelType sends the notification to Target as an email
+	emailChannelType = "email"
+	// primaryEmailChannel is the name used to route repos/orgs to the user's own email address
+	primaryEmailChannel = "primary-email"
+)
+
+// NotificationChannel is a destination the notifications are sent to, like a slack or generic webhook
+// Repos/Orgs can be routed to channels by name. Anything which is not routed falls back
+// to the primary email and the channels whose Repos filter accept it
+type NotificationChannel struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`   // email or one of config.WebhookIntegrations
+	Target  string   `yaml:"target"` // url or email address the notification is sent to
+	Enabled bool     `yaml:"enabled"`
+	Repos   []string `yaml:"repos,omitempty,flow"` // when set, only these repos/orgs are sent to the channel
+
+	Recipient string `yaml:"recipient,omitempty"` // room/chat at the Target, like the matrix room ID
+	Token     string `yaml:"token,omitempty"`     // access token used to post on the Target
+	Format    string `yaml:"format,omitempty"`    // format of the message, like the telegram parse mode
+	Secret    string `yaml:"secret,omitempty"`    // key used to sign the generic webhook deliveries
+	Template  string `yaml:"template,omitempty"`  // text/template rendering the payload of the generic webhook
+
+	Priority string   `yaml:"priority,omitempty"`  // priority of the push notifications of ntfy/gotify
+	Tags     []string `yaml:"tags,omitempty,flow"` // tags of the ntfy notifications
+
+	Pending bool `yaml:"pending,omitempty"` // email channel waiting for the link emailed to the Target to be opened
+
+	preview string // payload rendered from the latest changes, displayed on the settings page
+}
+
+// primaryEmail is the user's own email address represented as a channel
+func (c *Setting) primaryEmail() *NotificationChannel {
+	return &NotificationChannel{
+		Name:    primaryEmailChannel,
+		Type:    emailChannelType,
+		Target:  c.usersEmail(),
+		Enabled: true,
+	}
+}
+
+// ChannelTypes is used while displaying list of channel types available
+func ChannelTypes() []string {
+	types := []string{"", emailChannelType}
+	return append(types, config.WebhookIntegrations...)
+}
+
+// ChannelFormats is used while displaying the formats available for each channel type
+func ChannelFormats() map[string][]string {
+	return map[string][]string{
+		"slack":             {"", slackDigest, slackThreaded},
+		telegramChannelType: {telegramHTML, telegramMarkdownV2},
+	}
+}
+
+func (ch *NotificationChannel) String() string {
+	return fmt.Sprintf("%s(%s)", ch.Name, ch.Type)
+}
+
+func (ch *NotificationChannel) isValid() bool {
+	if !ch.Enabled || ch.Target == "" {
+		return false
+	}
+	if ch.Type == emailChannelType {
+		return config.isEmailSetup() && isValidEmail(ch.Target) && !ch.Pending
+	}
+	if (ch.Type == matrixChannelType || ch.Type == telegramChannelType || ch.Format == slackThreaded) && (ch.Recipient == "" || ch.Token == "") {
+		return false
+	}
+	if (ch.Type == ntfyChannelType && ch.Recipient == "") || (ch.Type == gotifyChannelType && ch.Token == "") {
+		return false
+	}
+	return StringIn(config.WebhookIntegrations, ch.Type)
+}
+
+// validate is used while saving the channel from the user/api
+func (ch *NotificationChannel) validate() error {
+	label := ch.Name
+	if label == "" {
+		label = ch.Target
+	}
+	if ch.Type == emailChannelType {
+		e, err := mail.ParseAddress(ch.Target)
+		if err != nil {
+			return fmt.Errorf("Channel %s: email address provided is invalid format", label)
+		}
+		ch.Target = e.Address
+	} else if !StringIn(config.WebhookIntegrations, ch.Type) {
+		return fmt.Errorf("Channel %s: type should be one of %s", label, strings.Join(ChannelTypes()[1:], ", "))
+	} else {
+		if ch.Type == telegramChannelType && ch.Target == "" {
+			ch.Target = telegramAPIURL
+		}
+		if ch.Type == ntfyChannelType && ch.Target == "" {
+			ch.Target = ntfyURL
+		}
+		if ch.Type == "slack" && ch.Format == slackThreaded && ch.Target == "" {
+			ch.Target = slackAPIURL
+		}
+		if _, err := url.ParseRequestURI(ch.Target); err != nil {
+			return fmt.Errorf("Channel %s: target URL is invalid", label)
+		}
+	}
+
+	if _, ok := ChannelFormats()[ch.Type]; !ok {
+		ch.Format = ""
+	}
+	if ch.Type != genericChannelType {
+		ch.Template = ""
+	}
+	if ch.Type != ntfyChannelType && ch.Type != gotifyChannelType {
+		ch.Priority = ""
+		ch.Tags = nil
+	}
+	switch ch.Type {
+	case genericChannelType:
+		if err := validateGenericChannel(ch, label); err != nil {
+			return err
+		}
+	case "slack":
+		if err := validateSlackChannel(ch, label); err != nil {
+			return err
+		}
+	case matrixChannelType:
+		if err := validateMatrixChannel(ch, label); err != nil {
+			return err
+		}
+	case telegramChannelType:
+		if err := validateTelegramChannel(ch, label); err != nil {
+			return err
+		}
+	case ntfyChannelType:
+		if err := validateNtfyChannel(ch, label); err != nil {
+			return err
+		}
+	case gotifyChannelType:
+		if err := validateGotifyChannel(ch, label); err != nil {
+			return err
+		}
+	}
+	for _, repo := range ch.Repos {
+		if validateRepoName(repo) == "" && validateOrgName(repo) == "" {
+			return fmt.Errorf("Channel %s: %q is not a valid repo or org name", label, repo)
+		}
+	}
+	return nil
+}
+
+// keepSecrets retains the token and secret saved earlier when the channel is updated without them
+// since they are not displayed back to the user
+func (ch *NotificationChannel) keepSecrets(u *UserNotification) {
+	if u == nil {
+		return
+	}
+	old := u.channelByName(ch.Name)
+	if old == nil || old.Type != ch.Type || old.Target != ch.Target {
+		return
+	}
+	if ch.Token == "" {
+		ch.Token = old.Token
+	}
+	if ch.Secret == "" {
+		ch.Secret = old.Secret
+	}
+}
+
+// HasToken is used by the view to show that a token is saved for the channel
+func (ch *NotificationChannel) HasToken() bool {
+	return ch.Token != ""
+}
+
+// HasSecret is used by the view to show that a signing secret is saved for the channel
+func (ch *NotificationChannel) HasSecret() bool {
+	return ch.Secret != ""
+}
+
+// Preview is used by the view to display the payload of the generic webhook template
+func (ch *NotificationChannel) Preview() string {
+	return ch.preview
+}
+
+// TagList is used by the view to display the tags of the push notifications
+func (ch *NotificationChannel) TagList() string {
+	return strings.Join(ch.Tags, ", ")
+}
+
+// RepoList is used by the view to display the repo filter
+func (ch *NotificationChannel) RepoList() string {
+	return strings.Join(ch.Repos, ", ")
+}
+
+// accepts checks the repo filter of the channel
+func (ch *NotificationChannel) accepts(repoName string) bool {
+	return len(ch.Repos) == 0 || StringIn(ch.Repos, repoName)
+}
+
+// routesFor returns the names of the channels a repo/org is routed to
+func (c *Setting) routesFor(name string) []string {
+	if strings.Contains(name, "/") {
+		for _, repo := range c.Repos {
+			if repo.Repo == name {
+				return repo.Channels
+			}
+		}
+		return nil
+	}
+	for _, org := range c.Orgs {
+		if org.Name == name {
+			return org.Channels
+		}
+	}
+	return nil
+}
+
+// diffsForChannel returns the part of the diff to be delivered on the channel
+// repos/orgs routed to channels go only to them, the rest fall back to the channels accepting them
+func (c *Setting) diffsForChannel(diffs gnDiffDatum, ch *NotificationChannel) gnDiffDatum {
+	var filtered gnDiffDatum
+	for _, diff := range diffs {
+		routes := c.routesFor(diff.Repo.Text)
+		if len(routes) > 0 {
+			if StringIn(routes, ch.Name) {
+				filtered = append(filtered, diff)
+			}
+		} else if ch.accepts(diff.Repo.Text) {
+			filtered = append(filtered, diff)
+		}
+	}
+	return filtered
+}
+
+// cleanRoutes keeps the names which are a channel of the user
+func (c *Setting) cleanRoutes(names []string) []string {
+	var routes []string
+	for _, name := range names {
+		if name == primaryEmailChannel || c.User.channelByName(name) != nil {
+			if !StringIn(routes, name) {
+				routes = append(routes, name)
+			}
+		}
+	}
+	return routes
+}
+
+func (u *UserNotification) channelByName(name string) *NotificationChannel {
+	for _, ch := range u.Channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// ChannelNames is used by the view to list the channels repos/orgs can be routed to
+func (u *UserNotification) ChannelNames() []string {
+	names := []string{primaryEmailChannel}
+	for _, ch := range u.Channels {
+		names = append(names, ch.Name)
+	}
+	return names
+}
+
+// migrateWebhook moves the single webhook that was supported earlier into the list of channels
+func (u *UserNotification) migrateWebhook() {
+	if u.WebhookURL == "" && u.WebhookType == "" {
+		return
+	}
+	if u.WebhookURL != "" && u.WebhookType != "" {
+		u.Channels = append(u.Channels, &NotificationChannel{
+			Type:    u.WebhookType,
+			Target:  u.WebhookURL,
+			Enabled: true,
+		})
+	}
+	u.WebhookURL = ""
+	u.WebhookType = ""
+	u.Channels = cleanChannels(u.Channels)
+}
+
+func (u *UserNotification) hasValidChannel() bool {
+	for _, ch := range u.Channels {
+		if ch.isValid() {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanChannels drops empty channels and sets unique names for the rest
+func cleanChannels(channels []*NotificationChannel) []*NotificationChannel {
+	var clean []*NotificationChannel
+	names := make(map[string]bool)
+	for _, ch := range channels {
+		if ch == nil || ch.Target == "" {
+			continue
+		}
+		ch.Name = strings.TrimSpace(ch.Name)
+		if len(ch.Name) > 50 {
+			ch.Name = ch.Name[0:50]
+		}
+		if ch.Name == "" || ch.Name == primaryEmailChannel || names[ch.Name] {
+			ch.Name = uniqueChannelName(ch.Type, names)
+		}
+		names[ch.Name] = true
+
+		var repos []string
+		for _, repo := range ch.Repos {
+			if repo = strings.TrimSpace(repo); repo != "" {
+				repos = append(repos, repo)
+			}
+		}
+		ch.Repos = repos
+
+		clean = append(clean, ch)
+		if len(clean) == maxChannels {
+			break
+		}
+	}
+	return clean
+}
+
+func uniqueChannelName(prefix string, names map[string]bool) string {
+	if prefix == "" {
+		prefix = "channel"
+	}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s-%d", prefix, i)
+		if !names[name] {
+			return name
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package gitnotify
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func channelNames(channels []*NotificationChannel) []string {
+	var names []string
+	for _, ch := range channels {
+		names = append(names, ch.Name)
+	}
+	return names
+}
+
+func TestCleanChannels(t *testing.T) {
+	many := make([]*NotificationChannel, maxChannels+2)
+	for i := range many {
+		many[i] = &NotificationChannel{Type: "slack", Target: "https://hooks.slack.com/services/a"}
+	}
+	tests := []struct {
+		name     string
+		channels []*NotificationChannel
+		want     []string
+	}{
+		{"empty target", []*NotificationChannel{{Name: "team", Type: "slack"}, nil}, nil},
+		{"unnamed", []*NotificationChannel{{Type: "slack", Target: "a"}, {Type: "slack", Target: "b"}}, []string{"slack-1", "slack-2"}},
+		{"duplicate", []*NotificationChannel{{Name: "team", Type: "slack", Target: "a"}, {Name: "team", Type: "discord", Target: "b"}}, []string{"team", "discord-1"}},
+		{"reserved", []*NotificationChannel{{Name: primaryEmailChannel, Type: "email", Target: "a@example.com"}}, []string{"email-1"}},
+		{"trimmed", []*NotificationChannel{{Name: " team ", Type: "slack", Target: "a"}}, []string{"team"}},
+		{"long", []*NotificationChannel{{Name: strings.Repeat("a", 60), Type: "slack", Target: "a"}}, []string{strings.Repeat("a", 50)}},
+		{"no type", []*NotificationChannel{{Target: "a"}}, []string{"channel-1"}},
+	}
+	for _, tt := range tests {
+		if got := channelNames(cleanChannels(tt.channels)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+	if got := len(cleanChannels(many)); got != maxChannels {
+		t.Errorf("expected at most %d channels, got %d", maxChannels, got)
+	}
+
+	cleaned := cleanChannels([]*NotificationChannel{{Name: "team", Type: "slack", Target: "a", Repos: []string{" rails/rails ", ""}}})
+	if !reflect.DeepEqual(cleaned[0].Repos, []string{"rails/rails"}) {
+		t.Errorf("expected the repo filter to be trimmed, got %q", cleaned[0].Repos)
+	}
+}
+
+func TestMigrateWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        *UserNotification
+		wantTypes   []string
+		wantTargets []string
+	}{
+		{"webhook", &UserNotification{WebhookType: "slack", WebhookURL: "https://hooks.slack.com/a"}, []string{"slack"}, []string{"https://hooks.slack.com/a"}},
+		{"appended", &UserNotification{
+			WebhookType: "slack", WebhookURL: "https://hooks.slack.com/a",
+			Channels: []*NotificationChannel{{Name: "team", Type: "discord", Target: "https://discord.com/a", Enabled: true}},
+		}, []string{"discord", "slack"}, []string{"https://discord.com/a", "https://hooks.slack.com/a"}},
+		{"type without url", &UserNotification{WebhookType: "slack"}, nil, nil},
+		{"nothing", &UserNotification{}, nil, nil},
+	}
+	for _, tt := range tests {
+		tt.user.migrateWebhook()
+		var types, targets []string
+		for _, ch := range tt.user.Channels {
+			types = append(types, ch.Type)
+			targets = append(targets, ch.Target)
+			if ch.Name == "" || !ch.Enabled {
+				t.Errorf("%s: expected an enabled channel with a name, got %+v", tt.name, ch)
+			}
+		}
+		if !reflect.DeepEqual(types, tt.wantTypes) || !reflect.DeepEqual(targets, tt.wantTargets) {
+			t.Errorf("%s: expected %q %q, got %q %q", tt.name, tt.wantTypes, tt.wantTargets, types, targets)
+		}
+		if tt.user.WebhookType != "" || tt.user.WebhookURL != "" {
+			t.Errorf("%s: expected the old webhook to be cleared", tt.name)
+		}
+	}
+}
+
+func TestChannelIsValid(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{WebhookIntegrations: []string{"slack"}}
+
+	tests := []struct {
+		name  string
+		ch    *NotificationChannel
+		valid bool
+	}{
+		{"slack", &NotificationChannel{Type: "slack", Target: "https://hooks.slack.com/a", Enabled: true}, true},
+		{"disabled", &NotificationChannel{Type: "slack", Target: "https://hooks.slack.com/a"}, false},
+		{"no target", &NotificationChannel{Type: "slack", Enabled: true}, false},
+		{"not integrated", &NotificationChannel{Type: "discord", Target: "https://discord.com/a", Enabled: true}, false},
+		{"email without smtp", &NotificationChannel{Type: emailChannelType, Target: "team@example.com", Enabled: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.ch.isValid(); got != tt.valid {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.valid, got)
+		}
+	}
+}
+
+func TestDiffsForChannel(t *testing.T) {
+	conf := &Setting{
+		Repos: []*Repo{{Repo: "rails/rails", Channels: []string{"team"}}, {Repo: "golang/go"}},
+		Orgs:  []*Organisation{{Name: "kubernetes", Channels: []string{primaryEmailChannel, "ops"}}},
+		User:  &UserNotification{Email: "alice@example.com"},
+	}
+	diffs := gnDiffDatum{
+		{Repo: link{Text: "rails/rails"}},
+		{Repo: link{Text: "golang/go"}},
+		{Repo: link{Text: "kubernetes"}},
+	}
+	tests := []struct {
+		name string
+		ch   *NotificationChannel
+		want []string
+	}{
+		{"routed", &NotificationChannel{Name: "team"}, []string{"rails/rails", "golang/go"}},
+		{"routed org", &NotificationChannel{Name: "ops"}, []string{"golang/go", "kubernetes"}},
+		{"primary email", conf.primaryEmail(), []string{"golang/go", "kubernetes"}},
+		{"repo filter", &NotificationChannel{Name: "go", Repos: []string{"golang/go", "rails/rails"}}, []string{"golang/go"}},
+		{"filter of other repos", &NotificationChannel{Name: "js", Repos: []string{"nodejs/node"}}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, diff := range conf.diffsForChannel(diffs, tt.ch) {
+			got = append(got, diff.Repo.Text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCleanRoutes(t *testing.T) {
+	conf := &Setting{User: &UserNotification{Channels: []*NotificationChannel{{Name: "team"}, {Name: "ops"}}}}
+	tests := []struct {
+		name   string
+		routes []string
+		want   []string
+	}{
+		{"channels", []string{"team", primaryEmailChannel}, []string{"team", primaryEmailChannel}},
+		{"unknown", []string{"team", "removed"}, []string{"team"}},
+		{"duplicate", []string{"ops", "ops"}, []string{"ops"}},
+		{"none", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := conf.cleanRoutes(tt.routes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
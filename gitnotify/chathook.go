@@ -0,0 +1,108 @@
+package gitnotify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mattermost and Rocket.Chat accept slack compatible incoming webhooks,
+// but render markdown instead of slack's mrkdwn and have their own size limits
+
+// chatFormat describes how a slack compatible product renders the message
+type chatFormat struct {
+	// MaxChars is the maximum characters of the text and attachments in a single message
+	MaxChars int
+	// MaxAttachments is the maximum attachments in a single message
+	MaxAttachments int
+	Link           func(text, href string) string
+	Bold           func(text string) string
+}
+
+// https://developers.mattermost.com/integrate/webhooks/incoming/
+var mattermostFormat = &chatFormat{
+	MaxChars:       16383,
+	MaxAttachments: 100,
+	Link:           markdownLink,
+	Bold:           func(text string) string { return "**" + text + "**" },
+}
+
+// https://docs.rocket.chat/use-rocket.chat/workspace-administration/integrations
+var rocketChatFormat = &chatFormat{
+	MaxChars:       5000,
+	MaxAttachments: 20,
+	Link:           markdownLink,
+	Bold:           func(text string) string { return "*" + text + "*" },
+}
+
+// [Amazon](http://www.amazon.com)
+func markdownLink(text, href string) string {
+	text = strings.NewReplacer("[", "\\[", "]", "\\]").Replace(text)
+	return fmt.Sprintf("[%s](%s)", text, href)
+}
+
+// chatMessages constructs messages per changed repo, split when they exceed the limits of the product
+func chatMessages(diffs gnDiffDatum, format *chatFormat) []*SlackMessage {
+	var messages []*SlackMessage
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
+		}
+
+		text := format.Bold("Changes for "+format.Link(repo.Repo.Text, repo.Repo.Href)) + ":"
+		newMessage := func() *SlackMessage {
+			message := &SlackMessage{Username: "gitnotify", Text: text}
+			messages = append(messages, message)
+			return message
+		}
+		message := newMessage()
+		size := len(text)
+
+		for _, attachment := range chatAttachments(repo, format) {
+			attachment.Text = truncateString(attachment.Text, format.MaxChars-len(text)-len(attachment.Title))
+			n := len(attachment.Title) + len(attachment.Text)
+			if len(message.Attachments) == format.MaxAttachments || size+n > format.MaxChars {
+				message = newMessage()
+				size = len(text)
+			}
+			message.Attachments = append(message.Attachments, attachment)
+			size += n
+		}
+	}
+	return messages
+}
+
+func chatAttachments(repo *gnDiffData, format *chatFormat) []SlackAttachment {
+	var attachments []SlackAttachment
+	for _, diff := range repo.Data {
+		if diff.Changed == false {
+			continue
+		}
+		if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
+			attachment := SlackAttachment{
+				Fallback:  diff.Title.Title + diff.Title.Text,
+				Title:     diff.Title.Title + diff.Title.Text,
+				TitleLink: diff.Title.Href,
+			}
+			if diff.Error == "" {
+				a := diff.Changes[0]
+				attachment.Text = format.Link(a.Text, a.Href)
+			} else {
+				attachment.Text = diff.Error
+			}
+			attachments = append(attachments, attachment)
+			continue
+		}
+
+		var links []string
+		for _, change := range diff.Changes {
+			links = append(links, format.Link(change.Text, change.Href))
+		}
+		attachments = append(attachments, SlackAttachment{
+			Fallback:  diff.heading(),
+			Title:     diff.heading(),
+			TitleLink: diff.Title.Href,
+			Text:      strings.Join(links, "\n"),
+		})
+	}
+	return attachments
+}
@@ -0,0 +1,64 @@
+package gitnotify
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMarkdownLink(t *testing.T) {
+	tests := []struct {
+		text, href, want string
+	}{
+		{"rails/rails", "https://github.com/rails/rails", "[rails/rails](https://github.com/rails/rails)"},
+		{"[wip] branch", "https://github.com/a", `[\[wip\] branch](https://github.com/a)`},
+	}
+	for _, tt := range tests {
+		if got := markdownLink(tt.text, tt.href); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestChatMessages(t *testing.T) {
+	branches := &gnDiffData{Repo: link{Text: "rails/rails"}, Changed: true}
+	for i := 0; i < rocketChatFormat.MaxAttachments+5; i++ {
+		branches.Data = append(branches.Data, diffData{Title: link{Text: fmt.Sprintf("branch-%d", i)}, ChangeType: "repoBranchDiff", Changed: true, Changes: []link{{Text: "abc..def"}}})
+	}
+	tests := []struct {
+		name        string
+		diffs       gnDiffDatum
+		format      *chatFormat
+		messages    int
+		attachments int
+	}{
+		{"mattermost", gnDiffDatum{testRepoDiff("rails/rails"), testRepoDiff("golang/go"), {Repo: link{Text: "nodejs/node"}}}, mattermostFormat, 2, 6},
+		{"more attachments than allowed", gnDiffDatum{branches}, rocketChatFormat, 2, rocketChatFormat.MaxAttachments + 5},
+		{"more characters than allowed", gnDiffDatum{testTagsDiff("rails/rails", 200)}, rocketChatFormat, 1, 1},
+		{"no changes", gnDiffDatum{{Repo: link{Text: "nodejs/node"}}}, mattermostFormat, 0, 0},
+	}
+	for _, tt := range tests {
+		messages := chatMessages(tt.diffs, tt.format)
+		attachments := 0
+		for _, message := range messages {
+			size := len(message.Text)
+			for _, a := range message.Attachments {
+				size += len(a.Title) + len(a.Text)
+			}
+			if len(message.Attachments) > tt.format.MaxAttachments || size > tt.format.MaxChars {
+				t.Errorf("%s: message exceeds the limits: %d attachments of %d characters", tt.name, len(message.Attachments), size)
+			}
+			attachments += len(message.Attachments)
+		}
+		if len(messages) != tt.messages || attachments != tt.attachments {
+			t.Errorf("%s: expected %d messages with %d attachments, got %d with %d", tt.name, tt.messages, tt.attachments, len(messages), attachments)
+		}
+	}
+
+	message := chatMessages(gnDiffDatum{testRepoDiff("rails/rails")}, rocketChatFormat)[0]
+	if want := "*Changes for [rails/rails](https://github.com/rails/rails)*:"; message.Text != want {
+		t.Errorf("expected the text %q, got %q", want, message.Text)
+	}
+	if got := message.Attachments[1].Text; got != "branch was deleted" {
+		t.Errorf("expected the error of the deleted branch, got %q", got)
+	}
+}
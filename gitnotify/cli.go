@@ -0,0 +1,401 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// This file provides the command line interface used for one-shot runs without the web server or cron
+//   gitnotify run --settings data/github/sairam/settings.yml [--format json] [--notify] [--save]
+//   gitnotify validate --settings path/to/settings.yml
+//   gitnotify list-diffs --settings path/to/settings.yml
+//   gitnotify show-diff --settings path/to/settings.yml 1489297210
+//   gitnotify unsubscribe-mail [--maildir path/to/Maildir] < message
+//   gitnotify bounce-mail [--maildir path/to/Maildir] < message
+
+const cliUsage = `Usage: gitnotify <command> [options]
+
+Commands:
+  run               fetch the latest changes for a settings file and print the diff
+  validate          check a settings file for errors
+  list-diffs        list the diffs saved for a settings file
+  show-diff         print a saved diff
+  unsubscribe-mail  process the mails sent to the List-Unsubscribe address
+  bounce-mail       stop emails to the addresses of the bounces received at fromEmail
+
+Run "gitnotify <command> -h" for the options of a command.
+Start without a command to run the web server.
+`
+
+type cliOptions struct {
+	configFile   string
+	settingsFile string
+	format       string
+}
+
+// RunCommand runs a command line subcommand and returns the exit code
+func RunCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "run":
+		err = cliRun(args[1:], os.Stdout)
+	case "validate":
+		err = cliValidate(args[1:], os.Stdout)
+	case "list-diffs":
+		err = cliListDiffs(args[1:], os.Stdout)
+	case "show-diff":
+		err = cliShowDiff(args[1:], os.Stdout)
+	case "unsubscribe-mail":
+		err = cliUnsubscribeMail(args[1:], os.Stdin, os.Stdout)
+	case "bounce-mail":
+		err = cliBounceMail(args[1:], os.Stdin, os.Stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func newCLIFlagSet(name string, opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "config.yml", "application config file")
+	fs.StringVar(&opts.settingsFile, "settings", "", "user settings file. eg: data/github/sairam/settings.yml")
+	fs.StringVar(&opts.format, "format", "text", "output format: text or json")
+	return fs
+}
+
+func (opts *cliOptions) validate() error {
+	if opts.settingsFile == "" {
+		return errors.New("--settings is required")
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return errors.New("--format should be text or json")
+	}
+	return nil
+}
+
+// loadCLISetting reads the application config and the user's settings
+func loadCLISetting(opts *cliOptions) (*Setting, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if err := readConfig(opts.configFile); err != nil {
+		return nil, fmt.Errorf("reading config %s: %s", opts.configFile, err)
+	}
+	initLinkEndPoints()
+
+	if _, err := os.Stat(opts.settingsFile); err != nil {
+		return nil, err
+	}
+	conf := new(Setting)
+	if err := conf.load(opts.settingsFile); err != nil {
+		return nil, fmt.Errorf("reading settings %s: %s", opts.settingsFile, err)
+	}
+	if conf.Auth == nil || conf.Auth.Provider == "" {
+		return nil, fmt.Errorf("settings %s does not have the auth section", opts.settingsFile)
+	}
+	return conf, nil
+}
+
+func cliRun(args []string, w io.Writer) error {
+	opts := &cliOptions{}
+	fs := newCLIFlagSet("run", opts)
+	notify := fs.Bool("notify", false, "send email/webhook notifications when there are changes")
+	save := fs.Bool("save", false, "save the diff and the fetched information back to the settings file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadCLISetting(opts)
+	if err != nil {
+		return err
+	}
+
+	if *notify {
+		if config.mailNeedsCredentials() && (config.SMTPUser == "" || config.SMTPPass == "") {
+			return errors.New("SMTP_USER and SMTP_PASS should be set to send emails")
+		}
+		InitView()
+		InitMail()
+	}
+
+	diffs, err := computeDiffForUser(conf)
+	if err != nil {
+		return err
+	}
+
+	var fileName string
+	if *save {
+		if fileName, err = diffs.save(conf); err != nil {
+			return err
+		}
+		if err = saveFetchedInfo(conf, opts.settingsFile); err != nil {
+			return err
+		}
+	}
+
+	if *notify {
+		notifyForUser(diffs, conf, fileName)
+	}
+
+	return writeDiffs(w, diffs, opts.format)
+}
+
+func cliValidate(args []string, w io.Writer) error {
+	opts := &cliOptions{}
+	fs := newCLIFlagSet("validate", opts)
+	remote := fs.Bool("remote", false, "check that repos and orgs exist at the provider")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadCLISetting(opts)
+	if err != nil {
+		return err
+	}
+
+	problems := validateSetting(conf, *remote)
+	for _, p := range problems {
+		fmt.Fprintln(w, "*", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found in %s", len(problems), opts.settingsFile)
+	}
+	fmt.Fprintf(w, "%s is valid\n", opts.settingsFile)
+	return nil
+}
+
+// validateSetting returns a list of human readable problems found in the settings
+func validateSetting(conf *Setting, remote bool) []string {
+	var problems []string
+
+	if conf.Auth.Provider != GithubProvider && conf.Auth.Provider != GitlabProvider {
+		problems = append(problems, fmt.Sprintf("auth: provider %q is not supported", conf.Auth.Provider))
+	}
+	if conf.Auth.UserName == "" {
+		problems = append(problems, "auth: username is empty")
+	}
+	if conf.Auth.Token == "" {
+		problems = append(problems, "auth: token is empty")
+	}
+
+	for _, repo := range conf.Repos {
+		if validateRepoName(repo.Repo) == "" {
+			problems = append(problems, fmt.Sprintf("repos: %q is not a valid repository name", repo.Repo))
+			continue
+		}
+		if !repo.Branches && !repo.Tags && len(repo.NamedReferences) == 0 {
+			problems = append(problems, fmt.Sprintf("repos: %q does not track branches, tags or commits", repo.Repo))
+		}
+		for _, name := range repo.Channels {
+			if !StringIn(conf.User.ChannelNames(), name) {
+				problems = append(problems, fmt.Sprintf("repos: %q is routed to an unknown channel %q", repo.Repo, name))
+			}
+		}
+		if remote && !validateRemoteRepoName(conf.Auth.Provider, conf.Auth.Token, repo.Repo) {
+			problems = append(problems, fmt.Sprintf("repos: %q could not be found on %s", repo.Repo, conf.Auth.Provider))
+		}
+	}
+
+	for _, org := range conf.Orgs {
+		if validateOrgName(org.Name) == "" {
+			problems = append(problems, fmt.Sprintf("orgs: %q is not a valid user/org name", org.Name))
+			continue
+		}
+		for _, name := range org.Channels {
+			if !StringIn(conf.User.ChannelNames(), name) {
+				problems = append(problems, fmt.Sprintf("orgs: %q is routed to an unknown channel %q", org.Name, name))
+			}
+		}
+		if remote {
+			if _, present := getRemoteOrgType(conf.Auth.Provider, conf.Auth.Token, org.Name); !present {
+				problems = append(problems, fmt.Sprintf("orgs: %q could not be found on %s", org.Name, conf.Auth.Provider))
+			}
+		}
+	}
+
+	u := conf.User
+	if u.Email != "" && !isValidEmail(u.Email) {
+		problems = append(problems, fmt.Sprintf("user_notification: email %q cannot receive emails", u.Email))
+	}
+	for _, ch := range u.Channels {
+		if err := ch.validate(); err != nil {
+			problems = append(problems, "user_notification: "+err.Error())
+		}
+	}
+	if u.TimeZoneName != "" && cleanTzName(u.TimeZoneName) != nil {
+		problems = append(problems, fmt.Sprintf("user_notification: tzname %q is not a valid TimeZone", u.TimeZoneName))
+	}
+	if u.Hour != cleanHour(strings.Split(u.Hour, ",")) {
+		problems = append(problems, fmt.Sprintf("user_notification: hour %q should be a comma separated list of 00-23", u.Hour))
+	}
+	if u.WeekDay != cleanWeekday(strings.Split(u.WeekDay, ",")) {
+		problems = append(problems, fmt.Sprintf("user_notification: weekday %q should be a comma separated list of 0-6", u.WeekDay))
+	}
+
+	return problems
+}
+
+func cliListDiffs(args []string, w io.Writer) error {
+	opts := &cliOptions{}
+	fs := newCLIFlagSet("list-diffs", opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadCLISetting(opts)
+	if err != nil {
+		return err
+	}
+
+	files := (&gnDiffDatum{}).ListUserChanges(conf)
+	if opts.format == "json" {
+		return json.NewEncoder(w).Encode(files)
+	}
+	for _, f := range files {
+		fmt.Fprintf(w, "%d\t%s\n", f.Reference, f.Display)
+	}
+	return nil
+}
+
+func cliShowDiff(args []string, w io.Writer) error {
+	opts := &cliOptions{}
+	fs := newCLIFlagSet("show-diff", opts)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: gitnotify show-diff --settings <file> <id>")
+	}
+
+	conf, err := loadCLISetting(opts)
+	if err != nil {
+		return err
+	}
+
+	id := fs.Arg(0)
+	if strings.Trim(id, "0123456789") != "" {
+		return fmt.Errorf("diff id %q should be numeric. Use list-diffs to find them", id)
+	}
+
+	diffs := gnDiffDatum{}
+	if err := diffs.load(id, conf); err != nil {
+		return err
+	}
+	return writeDiffs(w, diffs, opts.format)
+}
+
+func writeDiffs(w io.Writer, diffs gnDiffDatum, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	}
+	writeDiffText(w, diffs)
+	return nil
+}
+
+// writeDiffText is a plain text representation of the diff similar to changes_mail_text
+func writeDiffText(w io.Writer, diffs gnDiffDatum) {
+	if !diffs.hasChanges() {
+		fmt.Fprintln(w, "No changes")
+	}
+	for _, repo := range diffs {
+		if !repo.Changed {
+			fmt.Fprintf(w, "No Changes for %s\n", repo.Repo.Text)
+			continue
+		}
+		fmt.Fprintf(w, "Changes for %s %s\n", repo.Repo.Text, repo.Repo.Href)
+		for _, diff := range repo.Data {
+			if !diff.Changed {
+				continue
+			}
+			if diff.ChangeType == "repoBranchDiff" {
+				if diff.Error != "" {
+					fmt.Fprintf(w, "  ^ %s: %s\n", diff.Title.Text, diff.Error)
+					continue
+				}
+				for _, change := range diff.Changes {
+					fmt.Fprintf(w, "  * %s: %s %s\n", diff.Title.Text, change.Text, change.Href)
+				}
+				continue
+			}
+			fmt.Fprintf(w, "  %s\n", diff.heading())
+			for _, change := range diff.Changes {
+				fmt.Fprintf(w, "  * %s %s\n", change.Text, change.Href)
+			}
+		}
+	}
+}
+
+// cliUnsubscribeMail processes a mail piped from the MTA, or the new mails of a maildir
+func cliUnsubscribeMail(args []string, r io.Reader, w io.Writer) error {
+	fs := flag.NewFlagSet("unsubscribe-mail", flag.ContinueOnError)
+	configFile := fs.String("config", "config.yml", "application config file")
+	maildir := fs.String("maildir", "", "maildir receiving the mails of the unsubscribe address. reads a mail from stdin when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := readConfig(*configFile); err != nil {
+		return fmt.Errorf("reading config %s: %s", *configFile, err)
+	}
+	if config.UnsubscribeSecret == "" {
+		return errors.New("UNSUBSCRIBE_SECRET or SESSION_FS_STORE should be set")
+	}
+
+	if *maildir != "" {
+		return processUnsubscribeMaildir(*maildir, w)
+	}
+	if err := processUnsubscribeMail(r); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "unsubscribed")
+	return nil
+}
+
+// cliBounceMail processes a delivery status notification piped from the MTA, or the new mails of a maildir
+func cliBounceMail(args []string, r io.Reader, w io.Writer) error {
+	fs := flag.NewFlagSet("bounce-mail", flag.ContinueOnError)
+	configFile := fs.String("config", "config.yml", "application config file")
+	maildir := fs.String("maildir", "", "maildir receiving the bounces of fromEmail. reads a mail from stdin when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := readConfig(*configFile); err != nil {
+		return fmt.Errorf("reading config %s: %s", *configFile, err)
+	}
+	// the bounces are verified with the Message-ID signed using the secret
+	if config.UnsubscribeSecret == "" {
+		return errors.New("UNSUBSCRIBE_SECRET or SESSION_FS_STORE should be set")
+	}
+
+	if *maildir != "" {
+		return processMaildir(*maildir, w, processBounceMail, "processed")
+	}
+	if err := processBounceMail(r); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "processed")
+	return nil
+}
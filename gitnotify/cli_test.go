@@ -0,0 +1,107 @@
+package gitnotify
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCLIOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  *cliOptions
+		valid bool
+	}{
+		{"text", &cliOptions{settingsFile: "settings.yml", format: "text"}, true},
+		{"json", &cliOptions{settingsFile: "settings.yml", format: "json"}, true},
+		{"missing settings", &cliOptions{format: "text"}, false},
+		{"unknown format", &cliOptions{settingsFile: "settings.yml", format: "yaml"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.opts.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateSetting(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{WebhookIntegrations: []string{"slack"}}
+
+	tests := []struct {
+		name    string
+		change  func(*Setting)
+		problem string
+	}{
+		{"valid", func(*Setting) {}, ""},
+		{"provider", func(c *Setting) { c.Auth.Provider = "bitbucket" }, `provider "bitbucket" is not supported`},
+		{"token", func(c *Setting) { c.Auth.Token = "" }, "auth: token is empty"},
+		{"repo name", func(c *Setting) { c.Repos[0].Repo = "rails" }, `"rails" is not a valid repository name`},
+		{"nothing tracked", func(c *Setting) { c.Repos[0].Branches = false }, "does not track branches, tags or commits"},
+		{"unknown route", func(c *Setting) { c.Repos[0].Channels = []string{"team"} }, `routed to an unknown channel "team"`},
+		{"org route", func(c *Setting) { c.Orgs[0].Channels = []string{"team"} }, `orgs: "rails" is routed to an unknown channel "team"`},
+		{"email", func(c *Setting) { c.User.Email = "alice@users.noreply.github.com" }, "cannot receive emails"},
+		{"channel", func(c *Setting) {
+			c.User.Channels = []*NotificationChannel{{Name: "team", Type: "teams", Target: "https://example.com"}}
+		}, "Channel team: type should be one of"},
+		{"hour", func(c *Setting) { c.User.Hour = "25" }, `hour "25" should be`},
+		{"weekday", func(c *Setting) { c.User.WeekDay = "7" }, `weekday "7" should be`},
+	}
+	for _, tt := range tests {
+		conf := &Setting{
+			Auth:  &Authentication{Provider: GithubProvider, UserName: "alice", Token: "oauth"},
+			Repos: []*Repo{{Repo: "rails/rails", Branches: true}},
+			Orgs:  []*Organisation{{Name: "rails"}},
+			User:  &UserNotification{Email: "alice@example.com", Frequency: Frequency{Hour: "09", WeekDay: "1"}},
+		}
+		tt.change(conf)
+		problems := validateSetting(conf, false)
+		if tt.problem == "" {
+			if len(problems) > 0 {
+				t.Errorf("%s: expected no problems, got %q", tt.name, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.problem, problems)
+		}
+	}
+}
+
+func TestWriteDiffText(t *testing.T) {
+	diffs := gnDiffDatum{
+		{Repo: link{Text: "rails/rails", Href: "https://github.com/rails/rails"}, Changed: true, Data: []diffData{
+			{Title: link{Text: "main"}, ChangeType: "repoBranchDiff", Changed: true, Changes: []link{{Text: "abc..def", Href: "https://github.com/rails/rails/compare/abc...def"}}},
+			{Title: link{Text: "v5", Title: "New Tags"}, ChangeType: "repoTagDiff", Changed: true, Changes: []link{{Text: "v5.0.0", Href: "https://github.com/rails/rails/tree/v5.0.0"}}},
+			{Title: link{Text: "stale"}, ChangeType: "repoBranchDiff", Changed: false},
+			{Title: link{Text: "gone"}, ChangeType: "repoBranchDiff", Changed: true, Error: "branch was deleted"},
+		}},
+		{Repo: link{Text: "golang/go"}},
+	}
+	tests := []struct {
+		name  string
+		diffs gnDiffDatum
+		want  string
+	}{
+		{"changes", diffs, `Changes for rails/rails https://github.com/rails/rails
+  * main: abc..def https://github.com/rails/rails/compare/abc...def
+  New Tags
+  * v5.0.0 https://github.com/rails/rails/tree/v5.0.0
+  ^ gone: branch was deleted
+No Changes for golang/go
+`},
+		{"org", gnDiffDatum{testOrgDiff("kubernetes")}, `Changes for kubernetes https://github.com/kubernetes
+  kubernetes
+  * new-repo https://github.com/kubernetes/new-repo
+`},
+		{"no changes", gnDiffDatum{}, "No changes\n"},
+	}
+	for _, tt := range tests {
+		b := &bytes.Buffer{}
+		writeDiffText(b, tt.diffs)
+		if b.String() != tt.want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.want, b)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package gitnotify
 
 import (
+	"crypto/tls"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -23,10 +24,20 @@ type AppConfig struct {
 	GitlabURLEndPoint   string   `yaml:"gitlabURLEndPoint"` // website end point https://gitlab.com
 	SMTPHost            string   `yaml:"smtpHost"`
 	SMTPPort            int      `yaml:"smtpPort"`
-	SMTPSesConfSet      string   `yaml:"sesConfigurationSet"` // ses configuration set used as a custom header while sending email
+	SMTPTLS             string   `yaml:"smtpTLS"`                // none, starttls (default) or implicit
+	SMTPCAFile          string   `yaml:"smtpCAFile"`             // PEM bundle of the CAs trusted for smtpHost
+	SMTPSkipVerify      bool     `yaml:"smtpInsecureSkipVerify"` // skip verification of the certificate, only for testing
+	SMTPAuth            string   `yaml:"smtpAuth"`               // plain (default), login, cram-md5 or none
+	SMTPPoolSize        int      `yaml:"smtpPoolSize"`           // idle connections reused across emails
+	SMTPSesConfSet      string   `yaml:"sesConfigurationSet"`    // ses configuration set used as a custom header while sending email
+	MailTransport       string   `yaml:"mailTransport"`          // smtp (default), sendmail, maildir or file
+	SendmailPath        string   `yaml:"sendmailPath"`           // binary used by the sendmail transport, defaults to /usr/sbin/sendmail
+	MailDir             string   `yaml:"mailDir"`                // directory used by the maildir and file transports
+	SNSTopicARNs        []string `yaml:"snsTopicARNs"`           // SNS topics of the SES bounce and complaint notifications posted to /bounces/ses
 	GoogleAnalytics     string   `yaml:"googleAnalytics"`
 	SMTPUser            string   // environment variable
 	SMTPPass            string   // environment variable
+	UnsubscribeSecret   string   // environment variable UNSUBSCRIBE_SECRET, defaults to SESSION_FS_STORE
 	CacheMode           bool     `yaml:"cacheMode"` // when cacheMode is false, views are loaded on every request
 	WebhookIntegrations []string `yaml:"webhookIntegrations"`
 	SentryURL           string   `yaml:"sentryDSN"`
@@ -42,6 +53,8 @@ type AppConfig struct {
 	Providers map[string]string // List of ProviderNames that are configured as per auth
 
 	SourceCodeLink string
+
+	smtpTLSConfig *tls.Config
 }
 
 func (c *AppConfig) serverHostWithoutPort() string {
@@ -53,6 +66,12 @@ func (c *AppConfig) websiteURL() string {
 }
 
 func (c *AppConfig) isEmailSetup() bool {
+	switch c.MailTransport {
+	case mailTransportSendmail:
+		return c.SendmailPath != ""
+	case mailTransportMaildir, mailTransportFile:
+		return c.MailDir != ""
+	}
 	return c.SMTPHost != ""
 }
 
@@ -60,24 +79,11 @@ var config = new(AppConfig)
 
 // LoadConfig loads the config from the file
 func LoadConfig(appConfigFile string) {
-	if _, err := os.Stat(appConfigFile); os.IsNotExist(err) {
-		panic(err)
-	}
-
-	data, err := ioutil.ReadFile(appConfigFile)
-	if os.IsNotExist(err) {
-		panic(err)
-	}
-
-	err = yaml.Unmarshal(data, config)
-	if err != nil {
+	if err := readConfig(appConfigFile); err != nil {
 		panic(err)
 	}
 
-	if config.isEmailSetup() {
-		config.SMTPUser = os.Getenv("SMTP_USER")
-		config.SMTPPass = os.Getenv("SMTP_PASS")
-
+	if config.mailNeedsCredentials() {
 		// dont send email, but start the server but not the email daemon
 		if config.SMTPUser == "" {
 			panic("Missing Configuration: SMTP username is not set!")
@@ -92,21 +98,56 @@ func LoadConfig(appConfigFile string) {
 	initTZ()
 	preInitAuth()
 
-	// variables used by views
+	initLinkEndPoints()
+}
+
+// readConfig reads the config file and the environment variables without initialising the server
+func readConfig(appConfigFile string) error {
+	if _, err := os.Stat(appConfigFile); os.IsNotExist(err) {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(appConfigFile)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.Unmarshal(data, config)
+	if err != nil {
+		return err
+	}
+
+	if err = config.validateMailTransport(); err != nil {
+		return err
+	}
+
+	if config.mailNeedsCredentials() {
+		config.SMTPUser = os.Getenv("SMTP_USER")
+		config.SMTPPass = os.Getenv("SMTP_PASS")
+	}
+
+	config.UnsubscribeSecret = os.Getenv("UNSUBSCRIBE_SECRET")
+	if config.UnsubscribeSecret == "" {
+		config.UnsubscribeSecret = os.Getenv("SESSION_FS_STORE")
+	}
 
-	if config.Providers[GithubProvider] != "" {
+	config.SourceCodeLink = "https://github.com/sairam/gitnotify"
+	return nil
+}
+
+// variables used by views
+func initLinkEndPoints() {
+	if config.GithubURLEndPoint != "" && config.GithubAPIEndPoint != "" {
 		githubRepoEndPoint = config.GithubURLEndPoint + "%s/"                      // repo/abc
 		githubTreeURLEndPoint = config.GithubURLEndPoint + "%s/tree/%s"            // repo/abc , develop
 		githubCommitURLEndPoint = config.GithubURLEndPoint + "%s/commits/%s"       // repo/abc , develop
 		githubCompareURLEndPoint = config.GithubURLEndPoint + "%s/compare/%s...%s" // repo/abc, base, target commit ref
 	}
 
-	if config.Providers[GitlabProvider] != "" {
+	if config.GitlabURLEndPoint != "" && config.GitlabAPIEndPoint != "" {
 		gitlabRepoEndPoint = config.GitlabURLEndPoint + "%s/"                      // repo/abc
 		gitlabTreeURLEndPoint = config.GitlabURLEndPoint + "%s/tree/%s"            // repo/abc , develop
 		gitlabCommitURLEndPoint = config.GitlabURLEndPoint + "%s/commits/%s"       // repo/abc , develop
 		gitlabCompareURLEndPoint = config.GitlabURLEndPoint + "%s/compare/%s...%s" // repo/abc, base, target commit ref
 	}
-
-	config.SourceCodeLink = "https://github.com/sairam/gitnotify"
 }
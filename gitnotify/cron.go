@@ -53,7 +53,7 @@ func checkCronEntries(filename string) (nextRunTimes []string) {
 }
 
 func hasUserNotificationSet(s *Setting) bool {
-	return isValidEmail(s.usersEmail()) || s.User.isValidWebhook()
+	return isValidEmail(s.usersEmail()) || s.User.hasValidChannel()
 }
 
 func upsertCronEntry(s *Setting) {
@@ -132,8 +132,28 @@ func (t cronJob) Run() {
 	conf.load(filename)
 	processDiffForUser(conf)
 	if t.save {
-		conf.save(filename)
+		if err := saveFetchedInfo(conf, filename); err != nil {
+			log.Printf("Error saving %s: %s", filename, err)
+		}
+	}
+}
+
+// saveFetchedInfo saves the information fetched by a run into the latest settings of the user, which
+// could have changed while the repos were fetched, like an address marked invalid after a bounce
+func saveFetchedInfo(conf *Setting, filename string) error {
+	unlock := lockSetting(filename)
+	defer unlock()
+
+	latest := new(Setting)
+	if err := latest.load(filename); err != nil {
+		return err
+	}
+	if latest.Auth == nil {
+		// the settings were removed during the run
+		return nil
 	}
+	latest.Info = conf.Info
+	return latest.save(filename)
 }
 
 func startCronFor(cronEntry, filename string) {
@@ -145,6 +165,7 @@ func startCronFor(cronEntry, filename string) {
 func InitCron() {
 	crons = cron.New()
 	crons.Start()
+	crons.AddFunc(deliveryRetrySchedule, retryDeliveries)
 
 	if config.Providers[GithubProvider] != "" {
 		go getData(GithubProvider)
@@ -0,0 +1,69 @@
+package gitnotify
+
+import (
+	"html"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sairam/kinli"
+)
+
+type deliveriesPage struct {
+	Status     string
+	Statuses   []string
+	Deliveries []*delivery
+}
+
+// deliveriesHandler lists the recent email and webhook deliveries, optionally filtered by ?status=
+func deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	conf := new(Setting)
+	conf.load(configFile)
+
+	context := &deliveriesPage{
+		Status:   r.URL.Query().Get("status"),
+		Statuses: []string{deliveryPending, deliveryDelivered, deliveryFailed},
+	}
+	if !StringIn(context.Statuses, context.Status) {
+		context.Status = ""
+	}
+	for _, d := range listDeliveries(conf) {
+		if context.Status == "" || d.Status == context.Status {
+			context.Deliveries = append(context.Deliveries, d)
+		}
+	}
+
+	page := kinli.NewPage(hc, "Recent Deliveries", userInfo, context, nil)
+	kinli.DisplayPage(w, "deliveries", page)
+}
+
+// redeliverHandler attempts a delivery again
+func redeliverHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	conf := new(Setting)
+	conf.load(configFile)
+
+	d, err := findDelivery(conf, mux.Vars(r)["id"])
+	if err != nil {
+		hc.AddFlash(html.EscapeString(err.Error()))
+	} else if err = d.redeliver(); err != nil {
+		hc.AddFlash(html.EscapeString("Redelivery on " + d.Channel + " failed: " + err.Error() + ". It will be retried"))
+	} else {
+		hc.AddFlash(html.EscapeString("Redelivered on " + d.Channel))
+	}
+	http.Redirect(w, r, "/deliveries", 302)
+}
@@ -0,0 +1,632 @@
+package gitnotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook/chat notifications are delivered through a persistent queue
+// 1. the requests of a channel are rendered and saved as a pending delivery under data/$provider/$user/deliveries/
+// 2. the delivery is attempted right away. Requests which succeed are not sent again
+// 3. non 2xx responses and timeouts are retried with exponential backoff by the cron every minute
+// 4. after deliveryMaxAttempts the delivery is marked as failed and is listed at /deliveries to be redelivered
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+const (
+	deliveryMaxAttempts   = 8
+	deliveryInitialDelay  = time.Minute
+	deliveryMaxDelay      = 6 * time.Hour
+	deliveryTimeout       = 30 * time.Second
+	deliveryRetrySchedule = "@every 1m"
+	// rate limited requests are sent again within the attempt when asked to wait for up to this long
+	deliveryMaxRetryAfterWait = 5 * time.Second
+	// maximum number of finished deliveries kept for each user
+	deliveryMaxKept = 200
+	// maximum bytes of the response saved with the delivery
+	deliveryMaxResponse = 2048
+
+	deliveryDir           = "deliveries"
+	deliveryPendingSuffix = ".pending.json"
+	deliverySuffix        = ".json"
+)
+
+var (
+	deliveryLocker sync.Mutex
+	// deliveries being attempted, so that the cron does not pick them up in parallel
+	deliveriesInFlight = make(map[string]bool)
+)
+
+// delivery is the notification of a diff on a channel
+type delivery struct {
+	ID            string             `json:"id"`
+	User          string             `json:"user"` // provider/username
+	Channel       string             `json:"channel"`
+	ChannelType   string             `json:"channel_type"`
+	DiffID        string             `json:"diff_id,omitempty"`
+	Status        string             `json:"status"`
+	Attempts      int                `json:"attempts"`
+	CreatedAt     time.Time          `json:"created_at"`
+	LastAttemptAt time.Time          `json:"last_attempt_at,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at,omitempty"`
+	ResponseCode  int                `json:"response_code,omitempty"`
+	ResponseBody  string             `json:"response_body,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	ThreadTS      string             `json:"thread_ts,omitempty"`
+	LatencyMS     int64              `json:"latency_ms"` // of the last attempt
+	Requests      []*deliveryRequest `json:"requests"`
+	History       []*deliveryAttempt `json:"history,omitempty"`
+
+	dir     string               // directory the delivery is saved in
+	channel *NotificationChannel // loaded for the credentials of the requests
+}
+
+// deliveryRequest is a single HTTP request of a delivery
+type deliveryRequest struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Header   map[string]string `json:"header,omitempty"`
+	Body     string            `json:"body"`
+	ExpectOK bool              `json:"expect_ok,omitempty"` // the response is json with "ok": true, like the slack and telegram APIs
+	InThread bool              `json:"in_thread,omitempty"` // posted as a reply to the "ts" of the first response, like the slack threads
+	Sent     bool              `json:"sent"`
+	Auth     string            `json:"auth,omitempty"` // credentials of the channel added when the request is sent, see authorize
+}
+
+// The secrets/tokens of the channel are not saved with the delivery. They are read from the settings of the user
+// each time the request is sent, so that the retries use the current credentials of the channel
+const (
+	// timestamp and signature headers of the generic webhooks
+	deliveryAuthSignature = "signature"
+	// Authorization header with the token of the channel, like the matrix access token and the slack bot token
+	deliveryAuthBearer = "bearer"
+	// X-Gotify-Key header with the application token of the channel
+	deliveryAuthGotifyKey = "gotify-key"
+	// deliveryTokenPlaceholder of the url is replaced with the token of the channel, like the telegram bot token
+	deliveryAuthURLToken     = "url-token"
+	deliveryTokenPlaceholder = "{token}"
+)
+
+// deliveryAttempt is the outcome of an attempt displayed in the history of the delivery
+type deliveryAttempt struct {
+	At           time.Time `json:"at"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	LatencyMS    int64     `json:"latency_ms"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// deliveryResponse is the part of the responses used to check for success
+type deliveryResponse struct {
+	OK         *bool   `json:"ok"`
+	TS         string  `json:"ts"`
+	RetryAfter float64 `json:"retry_after"` // discord
+	Parameters struct {
+		RetryAfter float64 `json:"retry_after"` // telegram
+	} `json:"parameters"`
+}
+
+// deliveryError is returned when an attempt fails
+type deliveryError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *deliveryError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// newJSONRequest renders the data as the body of a request
+func newJSONRequest(method, url string, data interface{}) (*deliveryRequest, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryRequest{
+		Method: method,
+		URL:    url,
+		Header: map[string]string{"Content-Type": "application/json"},
+		Body:   string(body),
+	}, nil
+}
+
+func newDeliveryID() (string, error) {
+	suffix, err := randomHex(4)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", time.Now().Unix(), suffix), nil
+}
+
+func deliveriesDir(conf *Setting) string {
+	return strings.Join([]string{conf.Auth.getConfigDir(), deliveryDir}, string(os.PathSeparator))
+}
+
+// deliver saves the requests of the channel as a delivery and attempts it
+func deliver(conf *Setting, ch *NotificationChannel, deliveryID, fileName string, requests []*deliveryRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	d := &delivery{
+		ID:          deliveryID,
+		User:        conf.Auth.UserInfo(),
+		Channel:     ch.Name,
+		ChannelType: ch.Type,
+		DiffID:      fileName,
+		Status:      deliveryPending,
+		CreatedAt:   time.Now(),
+		Requests:    requests,
+		dir:         deliveriesDir(conf),
+		channel:     ch,
+	}
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return err
+	}
+	if !d.claim() {
+		return nil
+	}
+	defer d.release()
+
+	if err := d.save(); err != nil {
+		return err
+	}
+	return d.attempt()
+}
+
+func (d *delivery) claim() bool {
+	deliveryLocker.Lock()
+	defer deliveryLocker.Unlock()
+	if deliveriesInFlight[d.ID] {
+		return false
+	}
+	deliveriesInFlight[d.ID] = true
+	return true
+}
+
+func (d *delivery) release() {
+	deliveryLocker.Lock()
+	defer deliveryLocker.Unlock()
+	delete(deliveriesInFlight, d.ID)
+}
+
+// attempt sends the requests which were not sent yet and schedules the next attempt on failure
+func (d *delivery) attempt() error {
+	d.Attempts++
+	d.LastAttemptAt = time.Now()
+
+	client := &http.Client{Timeout: deliveryTimeout}
+	for _, req := range d.Requests {
+		if req.Sent {
+			continue
+		}
+		result, err := d.send(client, req)
+		if derr, ok := err.(*deliveryError); ok && derr.RetryAfter > 0 && derr.RetryAfter <= deliveryMaxRetryAfterWait {
+			time.Sleep(derr.RetryAfter)
+			result, err = d.send(client, req)
+		}
+		if err != nil {
+			d.failed(err)
+			d.addHistory(err)
+			if saveErr := d.save(); saveErr != nil {
+				log.Printf("Error saving delivery %s: %s", d.ID, saveErr)
+			}
+			return err
+		}
+		req.Sent = true
+		if d.ThreadTS == "" && result.TS != "" {
+			d.ThreadTS = result.TS
+		}
+	}
+
+	d.Status = deliveryDelivered
+	d.NextAttemptAt = time.Time{}
+	d.Error = ""
+	d.addHistory(nil)
+	return d.save()
+}
+
+// addHistory records the outcome of the attempt which started at LastAttemptAt
+func (d *delivery) addHistory(err error) {
+	d.LatencyMS = int64(time.Since(d.LastAttemptAt) / time.Millisecond)
+	a := &deliveryAttempt{
+		At:           d.LastAttemptAt,
+		ResponseCode: d.ResponseCode,
+		LatencyMS:    d.LatencyMS,
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	d.History = append(d.History, a)
+	if len(d.History) > 2*deliveryMaxAttempts {
+		d.History = d.History[len(d.History)-2*deliveryMaxAttempts:]
+	}
+}
+
+// recordDelivery saves a delivery which was sent without the queue, like the emails
+func recordDelivery(conf *Setting, ch *NotificationChannel, fileName string, req *deliveryRequest, start time.Time, err error) {
+	deliveryID, idErr := newDeliveryID()
+	if idErr != nil {
+		log.Print(idErr)
+		return
+	}
+	d := &delivery{
+		ID:            deliveryID,
+		User:          conf.Auth.UserInfo(),
+		Channel:       ch.Name,
+		ChannelType:   ch.Type,
+		DiffID:        fileName,
+		Status:        deliveryDelivered,
+		Attempts:      1,
+		CreatedAt:     start,
+		LastAttemptAt: start,
+		Requests:      []*deliveryRequest{req},
+		dir:           deliveriesDir(conf),
+	}
+	req.Sent = err == nil
+	if err != nil {
+		d.Status = deliveryFailed
+		d.Error = err.Error()
+	}
+	d.addHistory(err)
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := d.save(); err != nil {
+		log.Printf("Error saving delivery %s: %s", d.ID, err)
+	}
+}
+
+func (d *delivery) send(client *http.Client, r *deliveryRequest) (*deliveryResponse, error) {
+	body := r.Body
+	if r.InThread && d.ThreadTS != "" {
+		body = withThreadTS(body, d.ThreadTS)
+	}
+
+	d.ResponseCode = 0
+	d.ResponseBody = ""
+
+	req, err := http.NewRequest(r.Method, r.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, &deliveryError{err: err}
+	}
+	for k, v := range r.Header {
+		req.Header.Set(k, v)
+	}
+	if err = d.authorize(req, r, body); err != nil {
+		return nil, &deliveryError{err: err}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &deliveryError{err: sanitizeRequestError(err)}
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	d.ResponseCode = resp.StatusCode
+	d.ResponseBody = truncateString(string(respBody), deliveryMaxResponse)
+
+	result := &deliveryResponse{}
+	json.Unmarshal(respBody, result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &deliveryError{
+			StatusCode: resp.StatusCode,
+			Body:       d.ResponseBody,
+			RetryAfter: retryAfter(resp, result),
+		}
+	}
+	if r.ExpectOK && (result.OK == nil || !*result.OK) {
+		return nil, &deliveryError{StatusCode: resp.StatusCode, Body: d.ResponseBody, err: fmt.Errorf("responded without ok")}
+	}
+	return result, nil
+}
+
+// authorize adds the credentials of the channel to the request
+func (d *delivery) authorize(req *http.Request, r *deliveryRequest, body string) error {
+	if r.Auth == "" {
+		return nil
+	}
+	ch, err := d.loadChannel()
+	if err != nil {
+		return err
+	}
+	switch r.Auth {
+	case deliveryAuthSignature:
+		signWebhookRequest(req.Header, ch.Secret, []byte(body), time.Now())
+	case deliveryAuthBearer:
+		if ch.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+ch.Token)
+		}
+	case deliveryAuthGotifyKey:
+		req.Header.Set("X-Gotify-Key", ch.Token)
+	case deliveryAuthURLToken:
+		u, err := url.Parse(strings.Replace(r.URL, deliveryTokenPlaceholder, url.PathEscape(ch.Token), 1))
+		if err != nil {
+			return sanitizeRequestError(err)
+		}
+		req.URL = u
+	default:
+		return fmt.Errorf("unknown credentials %s", r.Auth)
+	}
+	return nil
+}
+
+// loadChannel reads the channel of the delivery from the settings of the user
+func (d *delivery) loadChannel() (*NotificationChannel, error) {
+	if d.channel != nil {
+		return d.channel, nil
+	}
+	providerUser := strings.SplitN(d.User, "/", 2)
+	if len(providerUser) != 2 {
+		return nil, fmt.Errorf("delivery %s has no user", d.ID)
+	}
+	auth := &Authentication{Provider: providerUser[0], UserName: providerUser[1]}
+	conf := new(Setting)
+	if err := conf.load(auth.getConfigFile()); err != nil {
+		return nil, err
+	}
+	for _, ch := range conf.User.Channels {
+		if ch.Name == d.Channel && ch.Type == d.ChannelType {
+			d.channel = ch
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("channel %s was removed", d.Channel)
+}
+
+// failed schedules the next attempt with exponential backoff or marks the delivery as failed
+// Rate limited deliveries are attempted after the wait asked by the response instead
+func (d *delivery) failed(err error) {
+	d.Error = err.Error()
+	if d.Attempts >= deliveryMaxAttempts {
+		d.Status = deliveryFailed
+		d.NextAttemptAt = time.Time{}
+		log.Printf("Delivery %s for %s on %s failed after %d attempts: %s", d.ID, d.User, d.Channel, d.Attempts, err)
+		return
+	}
+
+	delay := deliveryInitialDelay << uint(d.Attempts-1)
+	if delay > deliveryMaxDelay {
+		delay = deliveryMaxDelay
+	}
+	if derr, ok := err.(*deliveryError); ok && derr.RetryAfter > 0 {
+		delay = derr.RetryAfter
+	}
+	d.Status = deliveryPending
+	d.NextAttemptAt = time.Now().Add(delay)
+	log.Printf("Delivery %s for %s on %s failed, retrying at %s: %s", d.ID, d.User, d.Channel, d.NextAttemptAt, err)
+}
+
+// retryAfter reads the Retry-After header and the retry_after of discord/telegram responses
+func retryAfter(resp *http.Response, result *deliveryResponse) time.Duration {
+	seconds := result.RetryAfter
+	if seconds <= 0 {
+		seconds = result.Parameters.RetryAfter
+	}
+	if seconds <= 0 {
+		seconds, _ = strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// withThreadTS adds the thread_ts to the json body
+func withThreadTS(body, ts string) string {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		return body
+	}
+	data["thread_ts"] = ts
+	out, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return string(out)
+}
+
+// sanitizeRequestError drops the url from the error since it can contain tokens, like the telegram bot token
+func sanitizeRequestError(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return uerr.Err
+	}
+	return err
+}
+
+func (d *delivery) fileName(status string) string {
+	suffix := deliverySuffix
+	if status == deliveryPending {
+		suffix = deliveryPendingSuffix
+	}
+	return strings.Join([]string{d.dir, d.ID + suffix}, string(os.PathSeparator))
+}
+
+// save writes the delivery. Pending deliveries are saved with a separate suffix for the cron to find them
+func (d *delivery) save() error {
+	out, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err := saveCompressedFile(d.fileName(d.Status), out); err != nil {
+		return err
+	}
+	if d.Status == deliveryPending {
+		return nil
+	}
+	os.Remove(d.fileName(deliveryPending))
+	pruneDeliveries(d.dir)
+	return nil
+}
+
+func loadDelivery(fileName string) (*delivery, error) {
+	data, err := readCompressedFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	d := &delivery{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	d.dir = filepath.Dir(fileName)
+	return d, nil
+}
+
+// findDelivery loads a delivery of the user by its id
+func findDelivery(conf *Setting, id string) (*delivery, error) {
+	if !isSafePathName(id) {
+		return nil, fmt.Errorf("delivery %s not found", id)
+	}
+	d := &delivery{ID: id, dir: deliveriesDir(conf)}
+	return d.reload()
+}
+
+// reload reads the saved delivery again. A delivery loaded before it was claimed can be finished in between
+func (d *delivery) reload() (*delivery, error) {
+	for _, status := range []string{deliveryPending, deliveryDelivered} {
+		if _, err := os.Stat(d.fileName(status)); err == nil {
+			return loadDelivery(d.fileName(status))
+		}
+	}
+	return nil, fmt.Errorf("delivery %s not found", d.ID)
+}
+
+// listDeliveries returns the deliveries of the user, newest first
+func listDeliveries(conf *Setting) []*delivery {
+	files, _ := filepath.Glob(filepath.Join(deliveriesDir(conf), "*"+deliverySuffix))
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	deliveries := make([]*delivery, 0, len(files))
+	for _, file := range files {
+		d, err := loadDelivery(file)
+		if err != nil {
+			log.Printf("Error reading delivery %s: %s", file, err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
+
+// pruneDeliveries removes the oldest finished deliveries beyond deliveryMaxKept
+func pruneDeliveries(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+deliverySuffix))
+	var finished []string
+	for _, file := range files {
+		if !strings.HasSuffix(file, deliveryPendingSuffix) {
+			finished = append(finished, file)
+		}
+	}
+	if len(finished) <= deliveryMaxKept {
+		return
+	}
+	sort.Strings(finished)
+	for _, file := range finished[:len(finished)-deliveryMaxKept] {
+		os.Remove(file)
+	}
+}
+
+// redeliver attempts a delivery again from the request that failed
+func (d *delivery) redeliver() error {
+	if !d.CanRedeliver() {
+		return fmt.Errorf("delivery %s cannot be redelivered", d.ID)
+	}
+	if !d.claim() {
+		return fmt.Errorf("delivery %s is being attempted", d.ID)
+	}
+	defer d.release()
+
+	current, err := d.reload()
+	if err != nil {
+		return err
+	}
+	if !current.CanRedeliver() {
+		return fmt.Errorf("delivery %s cannot be redelivered", d.ID)
+	}
+	*d = *current
+
+	os.Remove(d.fileName(deliveryDelivered))
+	d.Status = deliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now()
+	if err := d.save(); err != nil {
+		return err
+	}
+	return d.attempt()
+}
+
+// retryDeliveries is run by the cron to attempt the pending deliveries which are due
+func retryDeliveries() {
+	files, err := filepath.Glob(filepath.Join(config.DataDir, "*", "*", deliveryDir, "*"+deliveryPendingSuffix))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		d, err := loadDelivery(file)
+		if err != nil {
+			log.Printf("Error reading delivery %s: %s", file, err)
+			continue
+		}
+		if d.NextAttemptAt.After(now) || !d.claim() {
+			continue
+		}
+		// skip the delivery when it was sent after it was loaded above
+		if current, err := d.reload(); err == nil && current.Status == deliveryPending {
+			current.attempt()
+		}
+		d.release()
+	}
+}
+
+// CanRedeliver is used by the view to display the redeliver button
+func (d *delivery) CanRedeliver() bool {
+	return d.ChannelType != emailChannelType && d.Status != deliveryDelivered
+}
+
+// BodyExcerpt is used by the view to display the start of the response
+func (d *delivery) BodyExcerpt() string {
+	return truncateString(strings.TrimSpace(d.ResponseBody), 300)
+}
+
+// Host is used by the view instead of the url, which can contain tokens
+func (r *deliveryRequest) Host() string {
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Host == "" {
+		return r.URL
+	}
+	return u.Host
+}
+
+// PrettyBody is used by the view to display the json body indented
+func (r *deliveryRequest) PrettyBody() string {
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, []byte(r.Body), "", "  "); err != nil {
+		return r.Body
+	}
+	return out.String()
+}
@@ -0,0 +1,347 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender is a webhook endpoint which fails the requests with a body in failures that many times
+type fakeSender struct {
+	sync.Mutex
+	failures map[string]int
+	bodies   []string
+}
+
+func (f *fakeSender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	if f.failures[string(body)] > 0 {
+		f.failures[string(body)]--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(`{"ok":true}`))
+}
+
+func (f *fakeSender) received() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.bodies...)
+}
+
+// withDataDir runs the test from a temporary directory since the settings are saved under data/
+func withDataDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "gitnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	oldConfig := config
+	config = &AppConfig{DataDir: "data", SettingsFile: "settings.yml"}
+	return func() {
+		config = oldConfig
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func testDelivery(url string, bodies ...string) (*Setting, *NotificationChannel, []*deliveryRequest) {
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{}}
+	ch := &NotificationChannel{Name: "hook", Type: "webhook", Target: url, Enabled: true}
+	var requests []*deliveryRequest
+	for _, body := range bodies {
+		requests = append(requests, &deliveryRequest{Method: "POST", URL: url, Body: body})
+	}
+	return conf, ch, requests
+}
+
+// makeDue moves the next attempt of the pending delivery to now
+func makeDue(t *testing.T, conf *Setting, id string) {
+	d, err := findDelivery(conf, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.NextAttemptAt = time.Now().Add(-time.Second)
+	if err = d.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliverAndRetry(t *testing.T) {
+	defer withDataDir(t)()
+	// the second request fails once, the first one should not be sent again
+	sender := &fakeSender{failures: map[string]int{"second": 1}}
+	server := httptest.NewServer(sender)
+	defer server.Close()
+
+	conf, ch, requests := testDelivery(server.URL, "first", "second")
+
+	if err := deliver(conf, ch, "1-a", "diff", requests); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	d, err := findDelivery(conf, "1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != deliveryPending || d.Attempts != 1 || !d.Requests[0].Sent || d.Requests[1].Sent {
+		t.Fatalf("unexpected delivery after the failed attempt: %+v", d)
+	}
+
+	// not due yet
+	retryDeliveries()
+	if got := len(sender.received()); got != 2 {
+		t.Fatalf("expected the retry to wait for the backoff, got %d requests", got)
+	}
+
+	makeDue(t, conf, "1-a")
+	retryDeliveries()
+	got := sender.received()
+	if len(got) != 3 || got[2] != "second" {
+		t.Fatalf("expected only the failed request to be sent again, got %q", got)
+	}
+	d, err = findDelivery(conf, "1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != deliveryDelivered || d.Attempts != 2 || len(d.History) != 2 {
+		t.Fatalf("unexpected delivery after the retry: %+v", d)
+	}
+	if _, err = os.Stat(d.fileName(deliveryPending)); !os.IsNotExist(err) {
+		t.Fatalf("expected the pending file to be removed, got %v", err)
+	}
+
+	retryDeliveries()
+	if got := len(sender.received()); got != 3 {
+		t.Fatalf("expected a delivered delivery not to be sent again, got %d requests", got)
+	}
+}
+
+func TestRetryDeliveriesSkipsClaimed(t *testing.T) {
+	defer withDataDir(t)()
+	sender := &fakeSender{failures: map[string]int{"only": 1}}
+	server := httptest.NewServer(sender)
+	defer server.Close()
+
+	conf, ch, requests := testDelivery(server.URL, "only")
+	deliver(conf, ch, "1-b", "diff", requests)
+	makeDue(t, conf, "1-b")
+
+	d, err := findDelivery(conf, "1-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.claim() {
+		t.Fatal("expected to claim the delivery")
+	}
+	if d.claim() {
+		t.Fatal("expected a claimed delivery not to be claimed again")
+	}
+	retryDeliveries()
+	if got := len(sender.received()); got != 1 {
+		t.Fatalf("expected a claimed delivery to be skipped, got %d requests", got)
+	}
+	d.release()
+
+	retryDeliveries()
+	if got := len(sender.received()); got != 2 {
+		t.Fatalf("expected the released delivery to be retried, got %d requests", got)
+	}
+}
+
+func TestReloadAfterDelivered(t *testing.T) {
+	defer withDataDir(t)()
+	sender := &fakeSender{failures: map[string]int{"only": 1}}
+	server := httptest.NewServer(sender)
+	defer server.Close()
+
+	conf, ch, requests := testDelivery(server.URL, "only")
+	deliver(conf, ch, "1-c", "diff", requests)
+
+	// a copy loaded by the cron before the delivery is sent by a redelivery
+	stale, err := loadDelivery((&delivery{ID: "1-c", dir: deliveriesDir(conf)}).fileName(deliveryPending))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := findDelivery(conf, "1-c")
+	if err = d.redeliver(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stale.Requests[0].Sent {
+		t.Fatal("expected the stale copy not to be updated")
+	}
+	current, err := stale.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != deliveryDelivered || !current.Requests[0].Sent {
+		t.Fatalf("expected the reloaded delivery to be delivered: %+v", current)
+	}
+	if err = stale.redeliver(); err == nil {
+		t.Fatal("expected a stale copy of a delivered delivery not to be redelivered")
+	}
+	if got := len(sender.received()); got != 2 {
+		t.Fatalf("expected 2 requests, got %d", got)
+	}
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		delay    time.Duration
+		status   string
+	}{
+		{"first", 1, &deliveryError{StatusCode: 500}, time.Minute, deliveryPending},
+		{"third", 3, &deliveryError{StatusCode: 500}, 4 * time.Minute, deliveryPending},
+		{"seventh", 7, &deliveryError{StatusCode: 500}, 64 * time.Minute, deliveryPending},
+		{"retry after", 2, &deliveryError{StatusCode: 429, RetryAfter: time.Hour}, time.Hour, deliveryPending},
+		{"short retry after", 3, &deliveryError{StatusCode: 429, RetryAfter: 10 * time.Second}, 10 * time.Second, deliveryPending},
+		{"last", deliveryMaxAttempts, &deliveryError{StatusCode: 500}, 0, deliveryFailed},
+	}
+	for _, tt := range tests {
+		d := &delivery{Attempts: tt.attempts}
+		start := time.Now()
+		d.failed(tt.err)
+		if d.Status != tt.status {
+			t.Errorf("%s: expected status %s, got %s", tt.name, tt.status, d.Status)
+		}
+		if tt.delay == 0 {
+			if !d.NextAttemptAt.IsZero() {
+				t.Errorf("%s: expected no next attempt, got %s", tt.name, d.NextAttemptAt)
+			}
+			continue
+		}
+		if delay := d.NextAttemptAt.Sub(start); delay < tt.delay || delay > tt.delay+time.Second {
+			t.Errorf("%s: expected a delay of %s, got %s", tt.name, tt.delay, delay)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		wait   time.Duration
+	}{
+		{"discord", "", `{"retry_after": 1.5}`, 1500 * time.Millisecond},
+		{"telegram", "", `{"ok": false, "parameters": {"retry_after": 3}}`, 3 * time.Second},
+		{"header", "120", `{}`, 2 * time.Minute},
+		{"body before header", "120", `{"retry_after": 2}`, 2 * time.Second},
+		{"none", "", `not json`, 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		result := &deliveryResponse{}
+		json.Unmarshal([]byte(tt.body), result)
+		if got := retryAfter(resp, result); got != tt.wait {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.wait, got)
+		}
+	}
+}
+
+func TestDeliverWaitsForRateLimit(t *testing.T) {
+	defer withDataDir(t)()
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.01}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	conf, ch, reqs := testDelivery(server.URL, "only")
+	if err := deliver(conf, ch, "1-d", "diff", reqs); err != nil {
+		t.Fatal(err)
+	}
+	d, err := findDelivery(conf, "1-d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || d.Status != deliveryDelivered || d.Attempts != 1 {
+		t.Fatalf("expected the rate limited request to be sent again in the same attempt, got %d requests: %+v", requests, d)
+	}
+}
+
+func TestRecordDelivery(t *testing.T) {
+	defer withDataDir(t)()
+	conf, _, _ := testDelivery("")
+	ch := conf.primaryEmail()
+
+	recordDelivery(conf, ch, "1", &deliveryRequest{Method: "SMTP", URL: "mailto:alice@example.com", Body: "sent"}, time.Now(), nil)
+	recordDelivery(conf, ch, "1", &deliveryRequest{Method: "SMTP", URL: "mailto:alice@example.com", Body: "bounced"}, time.Now(), errors.New("550 user unknown"))
+
+	deliveries := listDeliveries(conf)
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(deliveries))
+	}
+	statuses := map[string]string{}
+	for _, d := range deliveries {
+		statuses[d.Requests[0].Body] = d.Status
+		if len(d.History) != 1 || d.Attempts != 1 || d.CanRedeliver() {
+			t.Errorf("unexpected email delivery %+v", d)
+		}
+	}
+	if statuses["sent"] != deliveryDelivered || statuses["bounced"] != deliveryFailed {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestDeliveryHistoryIsCapped(t *testing.T) {
+	d := &delivery{}
+	for i := 0; i < 3*deliveryMaxAttempts; i++ {
+		d.LastAttemptAt = time.Now()
+		d.addHistory(fmt.Errorf("attempt %d", i))
+	}
+	if len(d.History) != 2*deliveryMaxAttempts {
+		t.Fatalf("expected %d attempts in the history, got %d", 2*deliveryMaxAttempts, len(d.History))
+	}
+	if last := d.History[len(d.History)-1].Error; last != fmt.Sprintf("attempt %d", 3*deliveryMaxAttempts-1) {
+		t.Errorf("expected the latest attempts to be kept, got %s", last)
+	}
+}
+
+func TestDeliveryView(t *testing.T) {
+	tests := []struct {
+		name       string
+		d          *delivery
+		redeliver  bool
+		host       string
+		prettyBody string
+	}{
+		{"failed webhook", &delivery{ChannelType: "slack", Status: deliveryFailed, Requests: []*deliveryRequest{{URL: "https://hooks.slack.com/services/T/B/secret", Body: `{"text":"hi"}`}}},
+			true, "hooks.slack.com", "{\n  \"text\": \"hi\"\n}"},
+		{"delivered webhook", &delivery{ChannelType: "slack", Status: deliveryDelivered, Requests: []*deliveryRequest{{URL: "https://hooks.slack.com/a", Body: "plain"}}},
+			false, "hooks.slack.com", "plain"},
+		{"email", &delivery{ChannelType: emailChannelType, Status: deliveryFailed, Requests: []*deliveryRequest{{URL: "mailto:alice@example.com", Body: "plain"}}},
+			false, "mailto:alice@example.com", "plain"},
+	}
+	for _, tt := range tests {
+		req := tt.d.Requests[0]
+		if tt.d.CanRedeliver() != tt.redeliver || req.Host() != tt.host || req.PrettyBody() != tt.prettyBody {
+			t.Errorf("%s: unexpected %v %q %q", tt.name, tt.d.CanRedeliver(), req.Host(), req.PrettyBody())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package gitnotify
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Limits from https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	discordMaxEmbeds     = 10
+	discordMaxEmbedChars = 6000 // total of all the embeds in a message
+	discordMaxTitle      = 256
+	discordMaxFields     = 25
+	discordMaxFieldName  = 256
+	discordMaxFieldValue = 1024
+	discordEmbedColor    = 0x2ecc71
+)
+
+// DiscordMessage ..
+type DiscordMessage struct {
+	Username string          `json:"username"`
+	Content  string          `json:"content,omitempty"`
+	Embeds   []*DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed ..
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	URL         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+}
+
+// DiscordEmbedField ..
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// size is the number of characters counted towards discordMaxEmbedChars
+func (e *DiscordEmbed) size() int {
+	n := len(e.Title) + len(e.Description)
+	for _, f := range e.Fields {
+		n += len(f.Name) + len(f.Value)
+	}
+	return n
+}
+
+// [Amazon](http://www.amazon.com)
+func discordLink(l link) string {
+	return fmt.Sprintf("[%s](%s)", l.Text, l.Href)
+}
+
+// discordRequests POSTs the messages on the webhook. Rate limited messages are retried after the retry_after
+func discordRequests(diffs gnDiffDatum, discordURL string) ([]*deliveryRequest, error) {
+	var requests []*deliveryRequest
+	for _, message := range discordMessages(diffs) {
+		req, err := newJSONRequest("POST", discordURL, message)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// discordMessages constructs one embed per changed repo and packs them into as few messages as allowed
+func discordMessages(diffs gnDiffDatum) []*DiscordMessage {
+	var messages []*DiscordMessage
+	var current *DiscordMessage
+	var currentSize int
+
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
+		}
+		for _, embed := range discordEmbeds(repo) {
+			if current == nil || len(current.Embeds) == discordMaxEmbeds || currentSize+embed.size() > discordMaxEmbedChars {
+				current = &DiscordMessage{Username: "gitnotify"}
+				currentSize = 0
+				messages = append(messages, current)
+			}
+			current.Embeds = append(current.Embeds, embed)
+			currentSize += embed.size()
+		}
+	}
+	return messages
+}
+
+// discordEmbeds returns the embeds for a repo. Repos with many changes are continued in more embeds
+func discordEmbeds(repo *gnDiffData) []*DiscordEmbed {
+	title := truncateString("Changes for "+repo.Repo.Text, discordMaxTitle)
+	newEmbed := func() *DiscordEmbed {
+		return &DiscordEmbed{Title: title, URL: repo.Repo.Href, Color: discordEmbedColor}
+	}
+
+	embeds := []*DiscordEmbed{newEmbed()}
+	addField := func(name, value string) {
+		field := DiscordEmbedField{
+			Name:  truncateString(name, discordMaxFieldName),
+			Value: truncateString(value, discordMaxFieldValue),
+		}
+		embed := embeds[len(embeds)-1]
+		if len(embed.Fields) == discordMaxFields || embed.size()+len(field.Name)+len(field.Value) > discordMaxEmbedChars {
+			embed = newEmbed()
+			embeds = append(embeds, embed)
+		}
+		embed.Fields = append(embed.Fields, field)
+	}
+
+	for _, diff := range repo.Data {
+		if diff.Changed == false {
+			continue
+		}
+		if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
+			if diff.Error != "" {
+				addField(diff.Title.Text, diff.Error)
+			} else {
+				addField(diff.Title.Text, discordLink(diff.Changes[0]))
+			}
+			continue
+		}
+
+		// new branches/tags are split over several fields when they do not fit in one
+		name := diff.heading()
+		var value string
+		for _, change := range diff.Changes {
+			l := discordLink(change)
+			if value != "" && len(value)+len(l)+1 > discordMaxFieldValue {
+				addField(name, value)
+				value = ""
+			}
+			if value != "" {
+				value += "\n"
+			}
+			value += l
+		}
+		if value != "" {
+			addField(name, value)
+		}
+	}
+	return embeds
+}
+
+// truncateString cuts s to max bytes, which is never more than max characters, without breaking a multi-byte character
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len("…")
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
@@ -0,0 +1,112 @@
+package gitnotify
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		max  int
+		want string
+	}{
+		{"short", "main", 10, "main"},
+		{"exact", "main", 4, "main"},
+		{"cut", "feature/long-name", 10, "feature…"},
+		{"multi-byte", "ブランチの名前", 10, "ブラ…"},
+		{"too small", "feature", 2, "…"},
+	}
+	for _, tt := range tests {
+		got := truncateString(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: expected a valid string, got %q", tt.name, got)
+		}
+	}
+}
+
+// testTagsDiff is a repo with count new tags
+func testTagsDiff(repo string, count int) *gnDiffData {
+	tags := make([]link, count)
+	for i := range tags {
+		tags[i] = link{Text: fmt.Sprintf("v%d.0.0", i), Href: fmt.Sprintf("https://github.com/%s/tree/v%d.0.0", repo, i)}
+	}
+	return &gnDiffData{
+		Repo:    link{Text: repo, Href: "https://github.com/" + repo},
+		Changed: true,
+		Data:    []diffData{{Title: link{Title: "New Tags"}, ChangeType: "repoTagDiff", Changed: true, Changes: tags}},
+	}
+}
+
+func TestDiscordMessages(t *testing.T) {
+	branches := &gnDiffData{Repo: link{Text: "rails/rails"}, Changed: true}
+	for i := 0; i < discordMaxFields+5; i++ {
+		branches.Data = append(branches.Data, diffData{Title: link{Text: fmt.Sprintf("branch-%d", i)}, ChangeType: "repoBranchDiff", Changed: true, Changes: []link{{Text: "abc..def"}}})
+	}
+	var manyRepos gnDiffDatum
+	for i := 0; i < discordMaxEmbeds+2; i++ {
+		manyRepos = append(manyRepos, testRepoDiff(fmt.Sprintf("rails/repo-%d", i)))
+	}
+	tests := []struct {
+		name     string
+		diffs    gnDiffDatum
+		messages int
+		embeds   int
+	}{
+		{"repo", gnDiffDatum{testRepoDiff("rails/rails"), {Repo: link{Text: "golang/go"}}}, 1, 1},
+		{"more repos than embeds", manyRepos, 2, discordMaxEmbeds + 2},
+		{"more fields than allowed", gnDiffDatum{branches}, 1, 2},
+		{"more characters than allowed", gnDiffDatum{testTagsDiff("rails/rails", 2000)}, 22, 22},
+		{"no changes", gnDiffDatum{{Repo: link{Text: "golang/go"}}}, 0, 0},
+	}
+	for _, tt := range tests {
+		messages := discordMessages(tt.diffs)
+		embeds := 0
+		for _, message := range messages {
+			size := 0
+			for _, embed := range message.Embeds {
+				size += embed.size()
+				if len(embed.Fields) > discordMaxFields || len(embed.Title) > discordMaxTitle {
+					t.Errorf("%s: embed exceeds the limits: %d fields", tt.name, len(embed.Fields))
+				}
+				for _, f := range embed.Fields {
+					if len(f.Value) > discordMaxFieldValue {
+						t.Errorf("%s: field of %d characters", tt.name, len(f.Value))
+					}
+				}
+			}
+			if len(message.Embeds) > discordMaxEmbeds || size > discordMaxEmbedChars {
+				t.Errorf("%s: message exceeds the limits: %d embeds of %d characters", tt.name, len(message.Embeds), size)
+			}
+			embeds += len(message.Embeds)
+		}
+		if len(messages) != tt.messages || embeds != tt.embeds {
+			t.Errorf("%s: expected %d messages with %d embeds, got %d with %d", tt.name, tt.messages, tt.embeds, len(messages), embeds)
+		}
+	}
+
+	fields := discordMessages(gnDiffDatum{testRepoDiff("rails/rails")})[0].Embeds[0].Fields
+	want := []string{
+		"main: [abc..def](https://github.com/rails/rails/compare/abc...def)",
+		"old: branch was deleted",
+		"New Tags: [v1](https://github.com/rails/rails/tree/v1)\n[v2](https://github.com/rails/rails/tree/v2)",
+	}
+	var got []string
+	for _, f := range fields {
+		got = append(got, f.Name+": "+f.Value)
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected the fields %q, got %q", want, got)
+	}
+
+	org := discordMessages(gnDiffDatum{testOrgDiff("kubernetes")})[0].Embeds[0].Fields
+	if len(org) != 1 || org[0].Name != "kubernetes" || org[0].Value != "[new-repo](https://github.com/kubernetes/new-repo)" {
+		t.Errorf("expected a field of the new repos named after the org, got %+v", org)
+	}
+}
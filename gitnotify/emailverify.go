@@ -0,0 +1,259 @@
+package gitnotify
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sairam/kinli"
+)
+
+// A changed email address is kept in UserNotification.PendingEmail until the link emailed to it is opened
+// Email channels to new addresses are Pending and are not sent to until the link is opened
+//
+//	/verify-email/TOKEN
+//
+// TOKEN is <base64 of provider/username, the address and the expiry>.<base64 of the HMAC-SHA256 using config.UnsubscribeSecret>
+// Emails are sent to the previous address until then. The confirmed addresses and the address of the OAuth provider (Auth.Email) are trusted
+const (
+	emailVerificationTTL = 7 * 24 * time.Hour
+	// signed along with the payload so that the tokens cannot be used as unsubscribe tokens
+	emailVerificationPurpose = "verify-email\n"
+)
+
+type invalidVerificationToken struct{}
+
+func (invalidVerificationToken) Error() string {
+	return "Confirmation link is invalid or has expired. Update the email address at /user to get a new link"
+}
+
+func emailVerificationToken(conf *Setting, address string, expiry time.Time) string {
+	payload := strings.Join([]string{conf.Auth.UserInfo(), address, strconv.FormatInt(expiry.Unix(), 10)}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + unsubscribeSignature(emailVerificationPurpose+payload)
+}
+
+// parseEmailVerificationToken returns the user and the address of a valid token
+func parseEmailVerificationToken(token string) (*Authentication, string, error) {
+	parts := strings.Split(token, ".")
+	if config.UnsubscribeSecret == "" || len(parts) != 2 {
+		return nil, "", &invalidVerificationToken{}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, "", &invalidVerificationToken{}
+	}
+	payload := string(data)
+	if !hmac.Equal([]byte(unsubscribeSignature(emailVerificationPurpose+payload)), []byte(parts[1])) {
+		return nil, "", &invalidVerificationToken{}
+	}
+
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 3 {
+		return nil, "", &invalidVerificationToken{}
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	providerUser := strings.SplitN(fields[0], "/", 2)
+	if err != nil || time.Now().Unix() > expiry || len(providerUser) != 2 || !isSafePathName(providerUser[1]) {
+		return nil, "", &invalidVerificationToken{}
+	}
+	return &Authentication{Provider: providerUser[0], UserName: providerUser[1]}, fields[1], nil
+}
+
+// isTrustedEmail is true for the confirmed addresses of the user and the address of the OAuth provider
+func (c *Setting) isTrustedEmail(address string) bool {
+	if strings.EqualFold(address, c.usersEmail()) || (c.Auth.Email != "" && strings.EqualFold(address, c.Auth.Email)) {
+		return true
+	}
+	for _, ch := range c.User.Channels {
+		if ch.Type == emailChannelType && !ch.Pending && strings.EqualFold(address, ch.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConfirmationSent is true when a link was emailed earlier to the address. it can be sent again from /user
+func (c *Setting) isConfirmationSent(address string) bool {
+	for _, pending := range c.User.PendingEmails() {
+		if strings.EqualFold(address, pending) {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingEmails are the addresses waiting for the confirmation link to be opened. Used by the view
+func (u *UserNotification) PendingEmails() []string {
+	var addresses []string
+	if u.PendingEmail != "" {
+		addresses = append(addresses, u.PendingEmail)
+	}
+	for _, ch := range u.Channels {
+		if ch.Type == emailChannelType && ch.Pending && !StringIn(addresses, ch.Target) {
+			addresses = append(addresses, ch.Target)
+		}
+	}
+	return addresses
+}
+
+// changeEmail uses the address when it can be trusted, or keeps it pending and emails a confirmation link
+// Returns true when the confirmation was sent. The settings should not be saved when an error is returned
+func (c *Setting) changeEmail(address string) (bool, error) {
+	if strings.EqualFold(address, c.usersEmail()) {
+		c.User.PendingEmail = ""
+		return false, nil
+	}
+	if c.isTrustedEmail(address) {
+		c.User.Email = address
+		c.User.PendingEmail = ""
+		return false, nil
+	}
+	if c.isConfirmationSent(address) {
+		c.User.PendingEmail = address
+		return false, nil
+	}
+	c.User.PendingEmail = address
+	return true, sendEmailVerification(c, address)
+}
+
+// setChannels replaces the channels of the user. The email channels to new addresses are pending
+// until the link emailed to them is opened. Returns the addresses the confirmation was sent to
+// The settings should not be saved when an error is returned
+func (c *Setting) setChannels(channels []*NotificationChannel) ([]string, error) {
+	var send []string
+	for _, ch := range channels {
+		ch.Pending = false
+		if ch.Type != emailChannelType || c.isTrustedEmail(ch.Target) {
+			continue
+		}
+		ch.Pending = true
+		if !c.isConfirmationSent(ch.Target) && !StringIn(send, ch.Target) {
+			send = append(send, ch.Target)
+		}
+	}
+	c.User.Channels = channels
+
+	for _, address := range send {
+		if err := sendEmailVerification(c, address); err != nil {
+			return nil, err
+		}
+	}
+	return send, nil
+}
+
+// sendEmailVerification emails the confirmation link to a pending address
+func sendEmailVerification(conf *Setting, address string) error {
+	if address == "" {
+		return errors.New("there is no email address to confirm")
+	}
+	if !config.isEmailSetup() || config.UnsubscribeSecret == "" {
+		return errors.New("confirmation emails cannot be sent right now. Try again later")
+	}
+
+	link := fmt.Sprintf("%s/verify-email/%s", config.websiteURL(), emailVerificationToken(conf, address, time.Now().Add(emailVerificationTTL)))
+	intro := fmt.Sprintf("Hi,\n\nConfirm that you want to receive the GitNotify updates of %s at %s by opening the link below.", conf.usersName(), address)
+	outro := fmt.Sprintf("The link expires in %d days. Ignore this email if you did not request it.", int(emailVerificationTTL.Hours()/24))
+
+	e := &kinli.EmailCtx{
+		From:      &mail.Address{Name: config.FromName, Address: config.FromEmail},
+		To:        []*mail.Address{{Address: address}},
+		Subject:   "[GitNotify] Confirm your email address",
+		PlainBody: intro + "\n\n" + link + "\n\n" + outro + "\n",
+		HTMLBody: fmt.Sprintf("<p>%s</p><p><a href=\"%s\">Confirm %s</a></p><p>%s</p>",
+			strings.Replace(html.EscapeString(intro), "\n", "<br>", -1), html.EscapeString(link), html.EscapeString(address), html.EscapeString(outro)),
+		Headers: map[string]string{},
+	}
+	if config.SMTPSesConfSet != "" {
+		e.Headers["X-SES-CONFIGURATION-SET"] = config.SMTPSesConfSet
+	}
+	return sendEmail(e)
+}
+
+// emailVerificationHandler confirms the pending address. The user need not be logged in
+func emailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	message := "Your email address is confirmed. The updates will be sent to it from the next run"
+
+	auth, address, err := parseEmailVerificationToken(mux.Vars(r)["token"])
+	if err == nil {
+		err = confirmEmail(auth, address)
+	}
+	if err != nil {
+		message = err.Error()
+	}
+	page := kinli.NewPage(hc, "Confirm Email", getUserInfo(hc), message, nil)
+	kinli.DisplayPage(w, "text", page)
+}
+
+// confirmEmail uses the address for the primary email and the email channels waiting for it
+func confirmEmail(auth *Authentication, address string) error {
+	configFile := auth.getConfigFile()
+	defer lockSetting(configFile)()
+	conf := new(Setting)
+	if err := conf.load(configFile); err != nil || conf.Auth == nil {
+		return &invalidVerificationToken{}
+	}
+
+	confirmed := false
+	if conf.User.PendingEmail != "" && strings.EqualFold(conf.User.PendingEmail, address) {
+		conf.User.Email = conf.User.PendingEmail
+		conf.User.PendingEmail = ""
+		confirmed = true
+	}
+	for _, ch := range conf.User.Channels {
+		if ch.Type == emailChannelType && ch.Pending && strings.EqualFold(ch.Target, address) {
+			ch.Pending = false
+			confirmed = true
+		}
+	}
+	if !confirmed {
+		// the link was opened again, or was sent to an address which was replaced later
+		if conf.isTrustedEmail(address) {
+			return nil
+		}
+		return &invalidVerificationToken{}
+	}
+
+	conf.pruneInvalidEmails()
+	if err := conf.save(configFile); err != nil {
+		return err
+	}
+	if crons != nil {
+		upsertCronEntry(conf)
+	}
+	log.Printf("Confirmed an email address of %s", conf.Auth.UserInfo())
+	return nil
+}
+
+// emailVerificationResendHandler sends the confirmation links of the pending addresses again
+func emailVerificationResendHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	conf := new(Setting)
+	conf.load(userInfo.getConfigFile())
+
+	pending := conf.User.PendingEmails()
+	for _, address := range pending {
+		if err := sendEmailVerification(conf, address); err != nil {
+			hc.AddFlash(html.EscapeString(err.Error()))
+			http.Redirect(w, r, "/user", 302)
+			return
+		}
+	}
+	if len(pending) > 0 {
+		hc.AddFlash(html.EscapeString("Confirmation link was sent to " + strings.Join(pending, ", ")))
+	}
+	http.Redirect(w, r, "/user", 302)
+}
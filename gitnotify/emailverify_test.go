@@ -0,0 +1,103 @@
+package gitnotify
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{UnsubscribeSecret: "unsubscribe-secret"}
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{}}
+	valid := emailVerificationToken(conf, "new@example.com", time.Now().Add(time.Hour))
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"expired", emailVerificationToken(conf, "new@example.com", time.Now().Add(-time.Second)), false},
+		{"tampered", "x" + valid, false},
+		{"unsubscribe token", unsubscribeToken(conf, conf.primaryEmail(), "", time.Now()), false},
+		{"other user", emailVerificationToken(&Setting{Auth: &Authentication{Provider: "github", UserName: ".."}}, "new@example.com", time.Now().Add(time.Hour)), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		auth, address, err := parseEmailVerificationToken(tt.token)
+		if tt.valid && (err != nil || auth.UserInfo() != "github/alice" || address != "new@example.com") {
+			t.Errorf("%s: expected the address of alice, got %v %q %v", tt.name, auth, address, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected the token to be rejected", tt.name)
+		}
+	}
+
+	config.UnsubscribeSecret = ""
+	if _, _, err := parseEmailVerificationToken(valid); err == nil {
+		t.Error("expected the token to be rejected without a secret")
+	}
+}
+
+func TestChangeAndConfirmEmail(t *testing.T) {
+	defer withDataDir(t)()
+	config.UnsubscribeSecret = "unsubscribe-secret"
+	config.MailTransport = mailTransportFile
+	config.MailDir = "mail"
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice", Email: "alice@github.example.com"}, User: &UserNotification{Email: "alice@example.com"}}
+	tests := []struct {
+		name    string
+		address string
+		sent    bool
+		email   string
+		pending string
+	}{
+		{"same address", "alice@example.com", false, "alice@example.com", ""},
+		{"address of the provider", "alice@github.example.com", false, "alice@github.example.com", ""},
+		{"new address", "new@example.com", true, "alice@github.example.com", "new@example.com"},
+		{"sent again", "new@example.com", false, "alice@github.example.com", "new@example.com"},
+	}
+	for _, tt := range tests {
+		sent, err := conf.changeEmail(tt.address)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if sent != tt.sent || conf.User.Email != tt.email || conf.User.PendingEmail != tt.pending {
+			t.Errorf("%s: unexpected %v %q %q", tt.name, sent, conf.User.Email, conf.User.PendingEmail)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join("mail", "*.eml")); len(files) != 1 {
+		t.Fatalf("expected a confirmation email, got %q", files)
+	}
+
+	sent, err := conf.setChannels([]*NotificationChannel{
+		{Name: "team", Type: emailChannelType, Target: "team@example.com", Enabled: true},
+		{Name: "me", Type: emailChannelType, Target: "alice@github.example.com", Enabled: true},
+	})
+	if err != nil || len(sent) != 1 || sent[0] != "team@example.com" {
+		t.Fatalf("expected a confirmation of the new channel address, got %q %v", sent, err)
+	}
+	if !conf.User.Channels[0].Pending || conf.User.Channels[1].Pending {
+		t.Errorf("expected only the new address to be pending")
+	}
+	saveTestSettings(t, conf, conf.User.Channels...)
+
+	for _, address := range []string{"new@example.com", "team@example.com", "team@example.com"} {
+		if err = confirmEmail(conf.Auth, address); err != nil {
+			t.Errorf("%s: %s", address, err)
+		}
+	}
+	if err = confirmEmail(conf.Auth, "other@example.com"); err == nil {
+		t.Error("expected an address which was not pending not to be confirmed")
+	}
+	latest := new(Setting)
+	if err = latest.load(conf.Auth.getConfigFile()); err != nil {
+		t.Fatal(err)
+	}
+	if latest.User.Email != "new@example.com" || latest.User.PendingEmail != "" || len(latest.User.PendingEmails()) != 0 {
+		t.Errorf("expected the addresses to be confirmed, got %q %q", latest.User.Email, latest.User.PendingEmails())
+	}
+}
@@ -0,0 +1,287 @@
+package gitnotify
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sairam/kinli"
+)
+
+// The saved changes of a user are available as a feed at /feed/<token>.atom and /feed/<token>.rss
+// The token is of the form <base64 of provider/username>.<secret> and is regenerated from the user settings
+const (
+	feedAtom       = "atom"
+	feedRSS        = "rss"
+	feedMaxEntries = 20
+)
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Link    []atomLink   `xml:"link"`
+	Author  atomAuthor   `xml:"author"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Summary string      `xml:"summary,omitempty"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// feedEntry is a saved diff of the user, rendered for either of the formats
+type feedEntry struct {
+	ID      string
+	Title   string
+	Link    string
+	Updated time.Time
+	Summary string
+	Content string
+}
+
+// newFeedToken replaces the feed token of the user, invalidating the earlier feed urls
+// Only the sha256 of the secret is persisted like the API tokens. The token is returned to be shown once
+// format is <base64 of provider/username>.<secret>
+func newFeedToken(conf *Setting) (string, error) {
+	secret, err := randomHex(20)
+	if err != nil {
+		return "", err
+	}
+	conf.FeedTokenHash = hashAPISecret(secret)
+	conf.FeedToken = ""
+	owner := base64.RawURLEncoding.EncodeToString([]byte(conf.Auth.UserInfo()))
+	return owner + "." + secret, nil
+}
+
+// migrateFeedToken replaces the secret saved by earlier versions with its hash
+func (c *Setting) migrateFeedToken() {
+	if c.FeedToken != "" {
+		c.FeedTokenHash = hashAPISecret(c.FeedToken)
+		c.FeedToken = ""
+	}
+}
+
+func feedURL(token, format string) string {
+	return fmt.Sprintf("%s/feed/%s.%s", config.websiteURL(), token, format)
+}
+
+// settingForFeedToken finds the owner of the feed token and loads their settings
+func settingForFeedToken(token string) (*Setting, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, &invalidAPIToken{}
+	}
+	conf, err := settingForOwner(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	hash := hashAPISecret(parts[1])
+	if conf.FeedTokenHash == "" || subtle.ConstantTimeCompare([]byte(conf.FeedTokenHash), []byte(hash)) != 1 {
+		return nil, &invalidAPIToken{}
+	}
+	return conf, nil
+}
+
+// feedEntries renders the latest saved diffs of the user with the changes template
+func feedEntries(conf *Setting) []*feedEntry {
+	files := (&gnDiffDatum{}).ListUserChanges(conf)
+	if len(files) > feedMaxEntries {
+		files = files[:feedMaxEntries]
+	}
+
+	entries := make([]*feedEntry, 0, len(files))
+	for _, file := range files {
+		fileName := fmt.Sprintf("%d", file.Reference)
+		diffs := gnDiffDatum{}
+		if err := diffs.load(fileName, conf); err != nil {
+			continue
+		}
+
+		var repos []string
+		for _, diff := range diffs {
+			if diff.Changed {
+				repos = append(repos, diff.Repo.Text)
+			}
+		}
+		content := &bytes.Buffer{}
+		kinli.DisplayPage(content, "changes_feed", diffs)
+
+		link := config.websiteURL() + "/changes/" + fileName
+		entries = append(entries, &feedEntry{
+			ID:      link,
+			Title:   "Changes from " + file.Display,
+			Link:    link,
+			Updated: time.Unix(file.Reference, 0).UTC(),
+			Summary: "Changes in " + strings.Join(repos, ", "),
+			Content: content.String(),
+		})
+	}
+	return entries
+}
+
+func atomFeedFor(title, selfURL, alternateURL string, updated time.Time, entries []*feedEntry) *atomFeed {
+	feed := &atomFeed{
+		ID:      selfURL,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Link: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: alternateURL, Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: "GitNotify"},
+	}
+	for _, e := range entries {
+		feed.Entries = append(feed.Entries, &atomEntry{
+			ID:      e.ID,
+			Title:   e.Title,
+			Updated: e.Updated.Format(time.RFC3339),
+			Link:    atomLink{Href: e.Link, Rel: "alternate", Type: "text/html"},
+			Summary: e.Summary,
+			Content: atomContent{Type: "html", Body: e.Content},
+		})
+	}
+	return feed
+}
+
+func rssFeedFor(title, alternateURL string, updated time.Time, entries []*feedEntry) *rssFeed {
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          alternateURL,
+			Description:   title,
+			LastBuildDate: updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range entries {
+		feed.Channel.Items = append(feed.Channel.Items, &rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{IsPermaLink: true, Value: e.ID},
+			PubDate:     e.Updated.Format(time.RFC1123Z),
+			Description: e.Content,
+		})
+	}
+	return feed
+}
+
+// writeFeed writes the entries in the format requested
+func writeFeed(w http.ResponseWriter, format, title, selfURL, alternateURL string, entries []*feedEntry) {
+	updated := time.Now().UTC()
+	if len(entries) > 0 {
+		updated = entries[0].Updated
+	}
+
+	var feed interface{}
+	contentType := "application/atom+xml; charset=utf-8"
+	if format == feedRSS {
+		feed = rssFeedFor(title, alternateURL, updated, entries)
+		contentType = "application/rss+xml; charset=utf-8"
+	} else {
+		feed = atomFeedFor(title, selfURL, alternateURL, updated, entries)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Last-Modified", updated.Format(http.TimeFormat))
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// userFeedHandler serves the saved changes of the user owning the token
+func userFeedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	conf, err := settingForFeedToken(vars["token"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	title := "GitNotify changes for " + conf.Auth.UserInfo()
+	writeFeed(w, vars["format"], title, feedURL(vars["token"], vars["format"]), config.websiteURL()+"/changes", feedEntries(conf))
+}
+
+// feedTokenHandler generates a new feed url for the user
+func feedTokenHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	defer lockSetting(configFile)()
+	conf := new(Setting)
+	conf.load(configFile)
+
+	token, err := newFeedToken(conf)
+	if err != nil {
+		hc.AddFlash(html.EscapeString("Error creating feed " + err.Error()))
+	} else if err = conf.save(configFile); err != nil {
+		hc.AddFlash(html.EscapeString("Error saving configuration " + err.Error()))
+	} else {
+		hc.AddFlash(fmt.Sprintf("Generated a new feed url. Copy it now, it will not be shown again. Earlier feed urls will not work anymore<br>Atom: <code>%s</code><br>RSS: <code>%s</code>",
+			html.EscapeString(feedURL(token, feedAtom)), html.EscapeString(feedURL(token, feedRSS))))
+	}
+
+	http.Redirect(w, r, "/user#feed", 302)
+}
@@ -0,0 +1,75 @@
+package gitnotify
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFeedToken(t *testing.T) {
+	defer withDataDir(t)()
+	config.Providers = map[string]string{"github": "GitHub"}
+	conf, _, _ := testDelivery("")
+	conf.Auth.Token = "oauth"
+
+	token, err := newFeedToken(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveTestSettings(t, conf)
+	saved, _ := ioutil.ReadFile(conf.Auth.getConfigFile())
+	secret := token[strings.LastIndex(token, ".")+1:]
+	if strings.Contains(string(saved), secret) || !strings.Contains(string(saved), hashAPISecret(secret)) {
+		t.Fatalf("expected only the hash of the secret to be saved: %s", saved)
+	}
+
+	owner := base64.RawURLEncoding.EncodeToString([]byte("github/alice"))
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"token", token, true},
+		{"wrong secret", owner + ".0123456789abcdef", false},
+		{"the hash as the secret", owner + "." + hashAPISecret(secret), false},
+		{"unknown user", base64.RawURLEncoding.EncodeToString([]byte("github/bob")) + "." + secret, false},
+		{"no secret", owner, false},
+	}
+	for _, tt := range tests {
+		c, err := settingForFeedToken(tt.token)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+		}
+		if err == nil && c.Auth.UserInfo() != "github/alice" {
+			t.Errorf("%s: expected the settings of github/alice, got %s", tt.name, c.Auth.UserInfo())
+		}
+	}
+
+	regenerated, _ := newFeedToken(conf)
+	saveTestSettings(t, conf)
+	if _, err = settingForFeedToken(token); err == nil {
+		t.Error("expected the earlier token to stop working")
+	}
+	if _, err = settingForFeedToken(regenerated); err != nil {
+		t.Errorf("expected the new token to work, got %v", err)
+	}
+}
+
+// the secrets saved by earlier versions keep working and are hashed
+func TestMigrateFeedToken(t *testing.T) {
+	defer withDataDir(t)()
+	config.Providers = map[string]string{"github": "GitHub"}
+	conf, _, _ := testDelivery("")
+	conf.FeedToken = "0123456789abcdef"
+	saveTestSettings(t, conf)
+
+	owner := base64.RawURLEncoding.EncodeToString([]byte("github/alice"))
+	c, err := settingForFeedToken(owner + ".0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FeedToken != "" || c.FeedTokenHash != hashAPISecret("0123456789abcdef") {
+		t.Fatalf("expected the secret to be replaced by its hash, got %q %q", c.FeedToken, c.FeedTokenHash)
+	}
+}
@@ -21,26 +21,19 @@ type MailContent struct {
 	SavedFile  string
 }
 
-// InitMail initialises mailer if config is setup
+// InitMail logs how the emails are sent. Connections to the smtp server are opened when sending
 func InitMail() {
-	if config.isEmailSetup() {
-		var smtpConfig = &kinli.EmailSMTPConfig{
-			Host: config.SMTPHost,
-			Port: config.SMTPPort,
-			User: config.SMTPUser,
-			Pass: config.SMTPPass,
-		}
-		kinli.InitMailer(smtpConfig)
-	} else {
+	if !config.isEmailSetup() {
 		log.Println("email is not configured")
+	} else if config.MailTransport == mailTransportSMTP {
+		log.Printf("emails are sent through %s:%d using %s TLS and %s auth", config.SMTPHost, config.SMTPPort, config.SMTPTLS, config.SMTPAuth)
+	} else {
+		log.Printf("emails are sent using the %s transport", config.MailTransport)
 	}
 }
 
-func processForMail(diff gnDiffDatum, conf *Setting, fileName string) error {
-	if config.isEmailSetup() == false || !isValidEmail(conf.usersEmail()) {
-		return nil
-	}
-
+// renderMail renders the html and plain text versions of the email
+func renderMail(diff gnDiffDatum, conf *Setting, fileName string) (string, string) {
 	mailContent := &MailContent{
 		WebsiteURL: config.websiteURL(),
 		User:       fmt.Sprintf("%s/%s", conf.Auth.Provider, conf.Auth.UserName),
@@ -59,9 +52,98 @@ func processForMail(diff gnDiffDatum, conf *Setting, fileName string) error {
 	plain := strings.Replace(string(text), "\n\n", "\n", -1)
 	plain = strings.Replace(plain, "\n\n", "\n", -1)
 
+	return string(html), plain
+}
+
+func processForMail(diff gnDiffDatum, conf *Setting, fileName string) error {
+	return processForMailTo(diff, conf, fileName, conf.primaryEmail())
+}
+
+// processForMailTo sends the diff to the email address of the channel
+// as a single email or an email for each repo, based on the preference of the user
+func processForMailTo(diff gnDiffDatum, conf *Setting, fileName string, ch *NotificationChannel) error {
+	if config.isEmailSetup() == false || !isValidEmail(ch.Target) || !diff.hasChanges() {
+		return nil
+	}
+	if ch.Pending {
+		log.Printf("Skipping email to %s until it is confirmed", ch.Target)
+		return nil
+	}
+	if conf.User.isEmailInvalid(ch.Target) {
+		log.Printf("Skipping email to %s since it bounced or complained", ch.Target)
+		return nil
+	}
+
+	if !conf.User.PerRepoEmails {
+		sendMail(diff, conf, fileName, ch, digestMailThread(conf))
+		return nil
+	}
+	for _, repoDiff := range diff {
+		if repoDiff.Changed {
+			sendMail(gnDiffDatum{repoDiff}, conf, fileName, ch, repoMailThread(conf, repoDiff.Repo.Text))
+		}
+	}
+	return nil
+}
+
+// mailThread has the subject and the headers deciding how the emails are grouped by the mail clients
+type mailThread struct {
+	Subject string
+	Repo    string // set when the thread is of a single repo
+	Headers map[string]string
+}
+
+// digestMailThread has all the changes of the user with a subject of the time
+func digestMailThread(conf *Setting) *mailThread {
 	loc, _ := time.LoadLocation(conf.User.TimeZoneName)
 	t := time.Now().In(loc)
-	subject := "[GitNotify] New Updates from your Repositories - " + t.Format("02 Jan 2006 | 15 Hrs")
+
+	headers := make(map[string]string)
+	// TODO - change constant gitnotify.com to config value
+	headers["List-ID"] = fmt.Sprintf("%s/%s <%s.%s.%s>",
+		conf.Auth.Provider, conf.Auth.UserName, conf.Auth.Provider, conf.Auth.UserName, "gitnotify.com")
+	return &mailThread{
+		Subject: "[GitNotify] New Updates from your Repositories - " + t.Format("02 Jan 2006 | 15 Hrs"),
+		Headers: headers,
+	}
+}
+
+// repoMailThread has the changes of a repo. The subject and the References are the same
+// for every email of the repo, so they are displayed in a single thread
+func repoMailThread(conf *Setting, repo string) *mailThread {
+	label := fmt.Sprintf("%s.%s.%s", mailLabel(repo), conf.Auth.Provider, mailLabel(conf.Auth.UserName))
+	root := fmt.Sprintf("<%s@%s>", label, config.serverHostWithoutPort())
+
+	headers := make(map[string]string)
+	headers["List-ID"] = fmt.Sprintf("%s <%s.%s>", repo, label, "gitnotify.com")
+	headers["In-Reply-To"] = root
+	headers["References"] = root
+	if id, err := randomHex(8); err == nil {
+		headers["Message-ID"] = fmt.Sprintf("<%d.%s.%s@%s>", time.Now().Unix(), id, label, config.serverHostWithoutPort())
+	}
+	return &mailThread{
+		Subject: "[GitNotify] " + repo,
+		Repo:    repo,
+		Headers: headers,
+	}
+}
+
+// mailLabel converts the name to the characters allowed in the List-ID/Message-ID, eg: rails/rails.js => rails-rails-js
+func mailLabel(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// sendMail sends an email of the diff in the thread and records it in the deliveries
+func sendMail(diff gnDiffDatum, conf *Setting, fileName string, ch *NotificationChannel, thread *mailThread) {
+	address := ch.Target
+	start := time.Now()
+
+	html, plain := renderMail(diff, conf, fileName)
 
 	fromEmail := &mail.Address{
 		Name:    config.FromName,
@@ -70,30 +152,36 @@ func processForMail(diff gnDiffDatum, conf *Setting, fileName string) error {
 
 	toEmail := &mail.Address{
 		Name:    conf.usersName(),
-		Address: conf.usersEmail(),
+		Address: address,
 	}
 
-	headers := make(map[string]string)
+	headers := thread.Headers
 	if config.SMTPSesConfSet != "" {
 		headers["X-SES-CONFIGURATION-SET"] = config.SMTPSesConfSet
 	}
 	headers["X-SES-MESSAGE-TAGS"] = fmt.Sprintf("%s=%s", conf.Auth.Provider, conf.Auth.UserName)
-	// TODO - change constant gitnotify.com to config value
-	headers["List-ID"] = fmt.Sprintf("%s/%s <%s.%s.%s>",
-		conf.Auth.Provider, conf.Auth.UserName, conf.Auth.Provider, conf.Auth.UserName, "gitnotify.com")
 	// m.SetHeader("List-Archive", fmt.Sprintf("")) // resource path like https://github.com/spf13/hugo
-	headers["List-Unsubscribe"] = fmt.Sprintf("<mailto:unsub+%s-%s@%s>, <%s>",
-		conf.Auth.Provider, conf.Auth.UserName, config.ServerHost, config.websiteURL())
+	for k, v := range unsubscribeHeaders(conf, ch, thread.Repo) {
+		headers[k] = v
+	}
 
 	e := &kinli.EmailCtx{
 		From:      fromEmail,
 		To:        []*mail.Address{toEmail},
-		Subject:   subject,
+		Subject:   thread.Subject,
 		PlainBody: plain,
-		HTMLBody:  string(html),
+		HTMLBody:  html,
 		Headers:   headers,
 	}
 
-	e.SendEmail()
-	return nil
+	err := sendEmail(e)
+	if err != nil {
+		log.Printf("Error sending email to %s: %s", address, err)
+	}
+
+	recordDelivery(conf, ch, fileName, &deliveryRequest{
+		Method: strings.ToUpper(config.MailTransport),
+		URL:    "mailto:" + address,
+		Body:   plain,
+	}, start, err)
 }
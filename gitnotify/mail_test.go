@@ -0,0 +1,63 @@
+package gitnotify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMailLabel(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"rails/rails", "rails-rails"},
+		{"rails/rails.js", "rails-rails-js"},
+		{"golang-go", "golang-go"},
+		{"user_name@corp", "user-name-corp"},
+	}
+	for _, tt := range tests {
+		if got := mailLabel(tt.name); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRepoMailThread(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com:3000"}
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice.b"}, User: &UserNotification{}}
+	first := repoMailThread(conf, "rails/rails.js")
+	second := repoMailThread(conf, "rails/rails.js")
+	other := repoMailThread(conf, "golang/go")
+
+	root := "<rails-rails-js.github.alice-b@gitnotify.com>"
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"subject", first.Subject, "[GitNotify] rails/rails.js"},
+		{"repo", first.Repo, "rails/rails.js"},
+		{"references", first.Headers["References"], root},
+		{"in reply to", first.Headers["In-Reply-To"], root},
+		{"same thread", second.Headers["References"], root},
+		{"list id", first.Headers["List-ID"], "rails/rails.js <rails-rails-js.github.alice-b.gitnotify.com>"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, tt.got)
+		}
+	}
+	if first.Headers["Message-ID"] == second.Headers["Message-ID"] || !strings.HasSuffix(first.Headers["Message-ID"], ".rails-rails-js.github.alice-b@gitnotify.com>") {
+		t.Errorf("expected a unique Message-ID in the thread, got %s and %s", first.Headers["Message-ID"], second.Headers["Message-ID"])
+	}
+	if other.Headers["References"] == root {
+		t.Error("expected another repo to be in another thread")
+	}
+
+	digest := digestMailThread(conf)
+	if digest.Repo != "" || digest.Headers["References"] != "" || !strings.HasPrefix(digest.Subject, "[GitNotify] New Updates") {
+		t.Errorf("expected the digest not to be threaded, got %+v", digest)
+	}
+}
@@ -0,0 +1,231 @@
+package gitnotify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sairam/kinli"
+)
+
+// Emails are sent using config.MailTransport
+//
+//	smtp     sends through the SMTP server at smtpHost using SMTP_USER and SMTP_PASS (default)
+//	sendmail pipes the message to the sendmail compatible binary at sendmailPath
+//	maildir  delivers the message into the Maildir at mailDir
+//	file     writes each message as a .eml file in mailDir, useful for development and tests
+const (
+	mailTransportSMTP     = "smtp"
+	mailTransportSendmail = "sendmail"
+	mailTransportMaildir  = "maildir"
+	mailTransportFile     = "file"
+
+	defaultSendmailPath = "/usr/sbin/sendmail"
+)
+
+func mailTransports() []string {
+	return []string{mailTransportSMTP, mailTransportSendmail, mailTransportMaildir, mailTransportFile}
+}
+
+// validateMailTransport sets the defaults of the transport and checks its settings
+func (c *AppConfig) validateMailTransport() error {
+	if c.MailTransport == "" {
+		c.MailTransport = mailTransportSMTP
+	}
+	if !StringIn(mailTransports(), c.MailTransport) {
+		return fmt.Errorf("mailTransport should be one of %s", strings.Join(mailTransports(), ", "))
+	}
+	if c.MailTransport == mailTransportSMTP && c.SMTPHost != "" {
+		if err := c.validateSMTP(); err != nil {
+			return err
+		}
+	}
+	if c.MailTransport == mailTransportSendmail && c.SendmailPath == "" {
+		c.SendmailPath = defaultSendmailPath
+	}
+	if (c.MailTransport == mailTransportMaildir || c.MailTransport == mailTransportFile) && c.MailDir == "" {
+		return fmt.Errorf("mailDir is required for the %s mail transport", c.MailTransport)
+	}
+	return nil
+}
+
+// mailNeedsCredentials is true when SMTP_USER and SMTP_PASS are required to send the emails
+func (c *AppConfig) mailNeedsCredentials() bool {
+	return c.MailTransport == mailTransportSMTP && c.SMTPHost != "" && c.SMTPAuth != smtpAuthNone
+}
+
+// sendEmail delivers the email through the configured transport
+func sendEmail(e *kinli.EmailCtx) error {
+	message, err := composeEmail(e)
+	if err != nil {
+		return err
+	}
+	switch config.MailTransport {
+	case mailTransportSMTP:
+		to := make([]string, 0, len(e.To))
+		for _, addr := range e.To {
+			to = append(to, addr.Address)
+		}
+		return smtpConnections.send(e.From.Address, to, message)
+	case mailTransportSendmail:
+		return sendmailDeliver(e, message)
+	case mailTransportMaildir:
+		return maildirDeliver(config.MailDir, message)
+	}
+	return fileDeliver(config.MailDir, message)
+}
+
+// composeEmail builds the multipart/alternative message with the plain text and html bodies
+func composeEmail(e *kinli.EmailCtx) ([]byte, error) {
+	headers := make(map[string]string)
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	to := make([]string, 0, len(e.To))
+	recipients := make([]string, 0, len(e.To))
+	for _, addr := range e.To {
+		to = append(to, addr.String())
+		recipients = append(recipients, addr.Address)
+	}
+	headers["From"] = e.From.String()
+	headers["To"] = strings.Join(to, ", ")
+	headers["Subject"] = mime.QEncoding.Encode("utf-8", e.Subject)
+	headers["Date"] = time.Now().Format(time.RFC1123Z)
+	headers["MIME-Version"] = "1.0"
+	if headers["Message-ID"] == "" {
+		id, err := randomHex(16)
+		if err != nil {
+			return nil, err
+		}
+		headers["Message-ID"] = fmt.Sprintf("<%s@%s>", id, config.serverHostWithoutPort())
+	}
+	// the bounces of the email are accepted only with the signed Message-ID
+	headers["Message-ID"] = signMessageID(headers["Message-ID"], recipients)
+
+	body := &bytes.Buffer{}
+	parts := multipart.NewWriter(body)
+	headers["Content-Type"] = "multipart/alternative; boundary=" + parts.Boundary()
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", e.PlainBody},
+		{"text/html; charset=utf-8", e.HTMLBody},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.content))
+		qp.Close()
+	}
+	parts.Close()
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	message := &bytes.Buffer{}
+	for _, k := range keys {
+		fmt.Fprintf(message, "%s: %s\r\n", k, headers[k])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func sendmailDeliver(e *kinli.EmailCtx, message []byte) error {
+	args := []string{"-i", "-f", e.From.Address, "--"}
+	for _, addr := range e.To {
+		args = append(args, addr.Address)
+	}
+	cmd := exec.Command(config.SendmailPath, args...)
+	cmd.Stdin = bytes.NewReader(message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sendmail: %s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// maildirDeliver writes the message in tmp and moves it to new, as described at https://cr.yp.to/proto/maildir.html
+func maildirDeliver(dir string, message []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	name, err := uniqueMailName()
+	if err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(dir, "tmp", name)
+	if err = ioutil.WriteFile(tmpFile, message, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filepath.Join(dir, "new", name))
+}
+
+func fileDeliver(dir string, message []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name, err := uniqueMailName()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name+".eml"), message, 0600)
+}
+
+func uniqueMailName() (string, error) {
+	suffix, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), suffix, strings.Replace(host, "/", "-", -1)), nil
+}
+
+// processMaildir calls process for each new mail of the maildir, writes the result to w and marks the mail as seen
+func processMaildir(dir string, w io.Writer, process func(io.Reader) error, done string) error {
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		fileName := filepath.Join(dir, "new", fi.Name())
+		file, err := os.Open(fileName)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %s\n", fi.Name(), err)
+			continue
+		}
+		err = process(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(w, "%s: %s\n", fi.Name(), err)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", fi.Name(), done)
+		}
+		// processed mails are moved to cur with the seen flag
+		if err = os.Rename(fileName, filepath.Join(dir, "cur", fi.Name()+":2,S")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
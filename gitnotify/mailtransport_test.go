@@ -0,0 +1,135 @@
+package gitnotify
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sairam/kinli"
+)
+
+func TestValidateMailTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *AppConfig
+		valid    bool
+		sendmail string
+	}{
+		{"default", &AppConfig{}, true, ""},
+		{"sendmail", &AppConfig{MailTransport: mailTransportSendmail}, true, defaultSendmailPath},
+		{"sendmail path", &AppConfig{MailTransport: mailTransportSendmail, SendmailPath: "/usr/bin/msmtp"}, true, "/usr/bin/msmtp"},
+		{"maildir", &AppConfig{MailTransport: mailTransportMaildir, MailDir: "Maildir"}, true, ""},
+		{"maildir without dir", &AppConfig{MailTransport: mailTransportMaildir}, false, ""},
+		{"file without dir", &AppConfig{MailTransport: mailTransportFile}, false, ""},
+		{"unknown", &AppConfig{MailTransport: "pigeon"}, false, ""},
+	}
+	for _, tt := range tests {
+		err := tt.config.validateMailTransport()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.valid && (tt.config.MailTransport == "" || tt.config.SendmailPath != tt.sendmail) {
+			t.Errorf("%s: unexpected defaults %q %q", tt.name, tt.config.MailTransport, tt.config.SendmailPath)
+		}
+	}
+}
+
+func testEmail() *kinli.EmailCtx {
+	return &kinli.EmailCtx{
+		From:      &mail.Address{Name: "GitNotify", Address: "notify@gitnotify.com"},
+		To:        []*mail.Address{{Name: "Alice", Address: "alice@example.com"}},
+		Subject:   "[GitNotify] Änderungen",
+		PlainBody: "Changes for rails/rails\n" + strings.Repeat("=", 100),
+		HTMLBody:  "<p>Changes for rails/rails</p>",
+		Headers:   map[string]string{"List-ID": "rails/rails <rails-rails.gitnotify.com>"},
+	}
+}
+
+func TestComposeEmail(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com", UnsubscribeSecret: "unsubscribe-secret"}
+
+	e := testEmail()
+	message, err := composeEmail(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"from", msg.Header.Get("From"), `"GitNotify" <notify@gitnotify.com>`},
+		{"to", msg.Header.Get("To"), `"Alice" <alice@example.com>`},
+		{"subject", subject, e.Subject},
+		{"list id", msg.Header.Get("List-ID"), e.Headers["List-ID"]},
+		{"mime", msg.Header.Get("MIME-Version"), "1.0"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, tt.got)
+		}
+	}
+	if !verifyMessageID(msg.Header.Get("Message-ID"), []string{"alice@example.com"}) {
+		t.Errorf("expected a signed Message-ID, got %s", msg.Header.Get("Message-ID"))
+	}
+	if _, ok := e.Headers["Message-ID"]; ok {
+		t.Error("expected the headers of the email not to be changed")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %s", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{{"text/plain", e.PlainBody}, {"text/html", e.HTMLBody}} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the quoted-printable part is decoded by the reader, with the line breaks as CRLF
+		body, _ := ioutil.ReadAll(part)
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), want.contentType) || string(body) != strings.Replace(want.body, "\n", "\r\n", -1) {
+			t.Errorf("expected the %s part %q, got %q", want.contentType, want.body, body)
+		}
+	}
+}
+
+func TestSendEmailToDirectories(t *testing.T) {
+	defer withDataDir(t)()
+	tests := []struct {
+		transport string
+		pattern   string
+	}{
+		{mailTransportMaildir, filepath.Join("mail", mailTransportMaildir, "new", "*")},
+		{mailTransportFile, filepath.Join("mail", mailTransportFile, "*.eml")},
+	}
+	for _, tt := range tests {
+		config.MailTransport = tt.transport
+		config.MailDir = filepath.Join("mail", tt.transport)
+		if err := sendEmail(testEmail()); err != nil {
+			t.Fatalf("%s: %s", tt.transport, err)
+		}
+		files, _ := filepath.Glob(tt.pattern)
+		if len(files) != 1 {
+			t.Fatalf("%s: expected a message at %s, got %q", tt.transport, tt.pattern, files)
+		}
+		message, _ := ioutil.ReadFile(files[0])
+		if _, err := mail.ReadMessage(strings.NewReader(string(message))); err != nil {
+			t.Errorf("%s: expected a mail message: %s", tt.transport, err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join("mail", mailTransportMaildir, "tmp", "*")); len(files) != 0 {
+		t.Errorf("expected the message to be moved out of tmp, got %q", files)
+	}
+}
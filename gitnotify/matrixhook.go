@@ -0,0 +1,84 @@
+package gitnotify
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Matrix notifications are sent as a room event using the client-server API
+// https://spec.matrix.org/latest/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
+
+// Target is the homeserver URL, Recipient the room ID and Token the access token of the channel
+const matrixChannelType = "matrix"
+
+// maximum size of an event is 65536 bytes including the envelope added by the homeserver
+const matrixMaxEventSize = 60000
+
+var matrixRoomIDRegex = regexp.MustCompile(`^![^:\s]+:[^\s]+$`)
+
+// reads the contents of the body from the html email
+var htmlBodyRegex = regexp.MustCompile(`(?is)<body[^>]*>(.*)</body>`)
+
+// MatrixMessage is the content of an m.room.message event
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+func validateMatrixChannel(ch *NotificationChannel, label string) error {
+	if !matrixRoomIDRegex.MatchString(ch.Recipient) {
+		return fmt.Errorf("Channel %s: room ID should be of the form !room:example.com", label)
+	}
+	if ch.Token == "" {
+		return fmt.Errorf("Channel %s: access token is required", label)
+	}
+	return nil
+}
+
+func matrixRequests(diff gnDiffDatum, conf *Setting, ch *NotificationChannel, fileName string) ([]*deliveryRequest, error) {
+	html, plain := renderMail(diff, conf, fileName)
+	// the transaction id makes the retries idempotent
+	sendURL, err := matrixSendURL(ch)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newJSONRequest("PUT", sendURL, matrixMessage(html, plain))
+	if err != nil {
+		return nil, err
+	}
+	// the access token is added when the request is sent so that it is not saved with the delivery
+	req.Auth = deliveryAuthBearer
+	return []*deliveryRequest{req}, nil
+}
+
+// matrixMessage prefers the html version and falls back to plain text when it is too large for an event
+func matrixMessage(html, plain string) *MatrixMessage {
+	if m := htmlBodyRegex.FindStringSubmatch(html); m != nil {
+		html = m[1]
+	}
+	message := &MatrixMessage{
+		MsgType:       "m.notice",
+		Body:          plain,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.TrimSpace(html),
+	}
+	if len(message.Body)+len(message.FormattedBody) > matrixMaxEventSize {
+		message.Format = ""
+		message.FormattedBody = ""
+		message.Body = truncateString(plain, matrixMaxEventSize)
+	}
+	return message
+}
+
+func matrixSendURL(ch *NotificationChannel) (string, error) {
+	txnID, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(ch.Target, "/"), url.PathEscape(ch.Recipient), txnID), nil
+}
@@ -0,0 +1,92 @@
+package gitnotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// the access token is read from the channel when the event is sent, and is not saved with the delivery
+func TestMatrixTokenAddedWhenSent(t *testing.T) {
+	defer withDataDir(t)()
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		if len(authorization) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer server.Close()
+
+	conf, _, _ := testDelivery(server.URL)
+	ch := &NotificationChannel{Name: "room", Type: matrixChannelType, Target: server.URL, Recipient: "!room:example.com", Token: "syt_secret_token", Enabled: true}
+	saveTestSettings(t, conf, ch)
+
+	reqs, err := channelRequests(sampleDiff(), conf, ch, "1-m", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqs[0].Header["Authorization"] != "" || strings.Contains(reqs[0].URL, ch.Token) {
+		t.Fatalf("expected the request not to have the token when rendered: %+v", reqs[0])
+	}
+	deliver(conf, ch, "1-m", "diff", reqs)
+
+	d, err := findDelivery(conf, "1-m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := readCompressedFile(d.fileName(deliveryPending))
+	if strings.Contains(string(saved), ch.Token) {
+		t.Fatalf("expected the token not to be saved: %s", saved)
+	}
+
+	// a retry uses the token of the channel at the time
+	ch.Token = "syt_rotated_token"
+	saveTestSettings(t, conf, ch)
+	if err = d.redeliver(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Bearer syt_secret_token", "Bearer syt_rotated_token"}
+	if strings.Join(authorization, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the Authorization headers %q, got %q", expected, authorization)
+	}
+}
+
+func TestMatrixMessage(t *testing.T) {
+	tests := []struct {
+		name, html, plain string
+		format, formatted string
+		body              string
+	}{
+		{"body of the html", "<html><body><p>Hi</p></body></html>", "Hi", "org.matrix.custom.html", "<p>Hi</p>", "Hi"},
+		{"html without body", " <p>Hi</p> ", "Hi", "org.matrix.custom.html", "<p>Hi</p>", "Hi"},
+		{"too large", "<p>" + strings.Repeat("a", matrixMaxEventSize) + "</p>", "plain", "", "", "plain"},
+	}
+	for _, tt := range tests {
+		m := matrixMessage(tt.html, tt.plain)
+		if m.MsgType != "m.notice" || m.Format != tt.format || m.FormattedBody != tt.formatted || m.Body != tt.body {
+			t.Errorf("%s: unexpected message %+v", tt.name, m)
+		}
+	}
+}
+
+func TestValidateMatrixChannel(t *testing.T) {
+	tests := []struct {
+		recipient, token string
+		valid            bool
+	}{
+		{"!abc:example.com", "token", true},
+		{"#room:example.com", "token", false},
+		{"!abc", "token", false},
+		{"!abc:example.com", "", false},
+	}
+	for _, tt := range tests {
+		err := validateMatrixChannel(&NotificationChannel{Recipient: tt.recipient, Token: tt.token}, "room")
+		if (err == nil) != tt.valid {
+			t.Errorf("%s %q: expected valid %v, got %v", tt.recipient, tt.token, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package gitnotify
+
+import (
+	"net/http"
+
+	"github.com/sairam/kinli"
+)
+
+// previewContent is what would be delivered on the next run
+type previewContent struct {
+	HasChanges bool
+	Channels   []*previewChannel
+}
+
+// previewChannel is what would be delivered on a channel, with its routing and payload template applied
+type previewChannel struct {
+	Name     string
+	Mails    []*previewMail
+	Requests []*deliveryRequest
+	Error    string
+}
+
+type previewMail struct {
+	HTML string
+	Text string
+}
+
+// previewHandler computes the diff like the cron job would, but does not persist or deliver it
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	// Redirect user if not logged in
+	if hc.RedirectUnlessAuthed(loginFlash) {
+		return
+	}
+	userInfo := getUserInfo(hc)
+	configFile := userInfo.getConfigFile()
+
+	conf := new(Setting)
+	conf.load(configFile)
+
+	// conf is not saved, so the fetched information is discarded
+	diffs, err := computeDiffForUser(conf)
+	if err != nil {
+		hc.AddFlash("Error computing changes " + err.Error())
+		http.Redirect(w, r, kinli.HomePathAuthed, 302)
+		return
+	}
+
+	page := kinli.NewPage(hc, "Preview of the Next Notification", userInfo, newPreviewContent(diffs, conf), nil)
+	kinli.DisplayPage(w, "preview", page)
+}
+
+// newPreviewContent renders the changes of each channel like processForMail and processForWebhook do
+func newPreviewContent(diffs gnDiffDatum, conf *Setting) *previewContent {
+	content := &previewContent{HasChanges: diffs.hasChanges()}
+	channels := append([]*NotificationChannel{conf.primaryEmail()}, conf.User.Channels...)
+	for _, ch := range channels {
+		if !ch.isValid() {
+			continue
+		}
+		channelDiff := conf.diffsForChannel(diffs, ch)
+		if !channelDiff.hasChanges() {
+			continue
+		}
+		content.Channels = append(content.Channels, previewForChannel(channelDiff, conf, ch))
+	}
+	return content
+}
+
+func previewForChannel(diff gnDiffDatum, conf *Setting, ch *NotificationChannel) *previewChannel {
+	preview := &previewChannel{Name: ch.String()}
+	if ch.Type != emailChannelType {
+		requests, err := channelRequests(diff, conf, ch, "preview", "")
+		if err != nil {
+			preview.Error = err.Error()
+		}
+		preview.Requests = requests
+		return preview
+	}
+
+	if conf.User.isEmailInvalid(ch.Target) {
+		preview.Error = "Emails to " + ch.Target + " are not sent since it bounced or complained"
+		return preview
+	}
+	if !conf.User.PerRepoEmails {
+		preview.Mails = []*previewMail{newPreviewMail(diff, conf)}
+		return preview
+	}
+	for _, repoDiff := range diff {
+		if repoDiff.Changed {
+			preview.Mails = append(preview.Mails, newPreviewMail(gnDiffDatum{repoDiff}, conf))
+		}
+	}
+	return preview
+}
+
+func newPreviewMail(diff gnDiffDatum, conf *Setting) *previewMail {
+	html, text := renderMail(diff, conf, "")
+	return &previewMail{HTML: html, Text: text}
+}
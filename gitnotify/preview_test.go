@@ -0,0 +1,50 @@
+package gitnotify
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPreviewContent(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com", WebhookIntegrations: []string{"slack", genericChannelType}}
+
+	conf := &Setting{
+		Auth:  &Authentication{Provider: GithubProvider, UserName: "alice"},
+		Repos: []*Repo{{Repo: "rails/rails", Channels: []string{"team"}}, {Repo: "golang/go"}},
+		User: &UserNotification{Channels: []*NotificationChannel{
+			{Name: "team", Type: "slack", Target: "https://hooks.slack.com/services/a", Enabled: true},
+			{Name: "hook", Type: genericChannelType, Target: "https://example.com/hook", Enabled: true, Template: `{"repos": [{{ range $i, $d := .Diffs }}{{ if $i }},{{ end }}{{ json $d.Repo.Text }}{{ end }}]}`},
+			{Name: "js", Type: genericChannelType, Target: "https://example.com/js", Enabled: true, Repos: []string{"nodejs/node"}},
+			{Name: "broken", Type: genericChannelType, Target: "https://example.com/broken", Enabled: true, Template: `{"x": {{ .Summary }}}`},
+			{Name: "off", Type: "slack", Target: "https://hooks.slack.com/services/b"},
+		}},
+	}
+	diffs := gnDiffDatum{testRepoDiff("rails/rails"), testRepoDiff("golang/go")}
+
+	content := newPreviewContent(diffs, conf)
+	if !content.HasChanges {
+		t.Error("expected the preview to have changes")
+	}
+	var names []string
+	for _, ch := range content.Channels {
+		names = append(names, ch.Name)
+	}
+	// the email is not set up, the js channel has no changes and the off channel is disabled
+	if want := []string{"team(slack)", "hook(generic)", "broken(generic)"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected the channels %q, got %q", want, names)
+	}
+
+	team, hook, broken := content.Channels[0], content.Channels[1], content.Channels[2]
+	if len(team.Requests) != 2 || !strings.Contains(team.Requests[0].Body, "rails/rails") || !strings.Contains(team.Requests[1].Body, "golang/go") {
+		t.Errorf("expected a slack message for each routed repo, got %+v", team.Requests)
+	}
+	if len(hook.Requests) != 1 || hook.Requests[0].Body != `{"repos":["golang/go"]}` {
+		t.Errorf("expected the payload template with the repos routed to the channel, got %+v", hook.Requests)
+	}
+	if broken.Error == "" || len(broken.Requests) != 0 {
+		t.Errorf("expected the error of the payload template, got %+v", broken)
+	}
+}
@@ -0,0 +1,169 @@
+package gitnotify
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ntfy and gotify channels push a short summary of the changes with a link to the full changes
+// https://docs.ntfy.sh/publish/ and https://gotify.net/api-docs#/message/createMessage
+//
+// ntfy: Target is the server, Recipient the topic and Token an optional access token
+// gotify: Target is the server and Token the application token
+// Priority of the channel is 1-5 or min/low/default/high/max for ntfy, 0-10 for gotify
+// Tags are displayed as emojis or labels by ntfy and are not supported by gotify
+const (
+	ntfyChannelType   = "ntfy"
+	gotifyChannelType = "gotify"
+)
+
+const (
+	ntfyURL = "https://ntfy.sh"
+
+	pushTitle          = "GitNotify"
+	pushMaxMessageSize = 1024
+	pushMaxTags        = 5
+	// number of new branches/tags/repos named for each repo in the summary
+	pushMaxRefs = 3
+)
+
+var ntfyTopicRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+// tags are sent comma separated in a header, like the emoji short codes warning or +1
+var ntfyTagRegex = regexp.MustCompile(`^[-_+A-Za-z0-9]{1,32}$`)
+
+var ntfyPriorities = []string{"1", "2", "3", "4", "5", "min", "low", "default", "high", "max", "urgent"}
+
+// GotifyMessage ..
+type GotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority *int                   `json:"priority,omitempty"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func validateNtfyChannel(ch *NotificationChannel, label string) error {
+	if !ntfyTopicRegex.MatchString(ch.Recipient) {
+		return fmt.Errorf("Channel %s: topic should have only letters, numbers, - and _", label)
+	}
+	if ch.Priority != "" && !StringIn(ntfyPriorities, strings.ToLower(ch.Priority)) {
+		return fmt.Errorf("Channel %s: priority should be one of %s", label, strings.Join(ntfyPriorities, ", "))
+	}
+	ch.Priority = strings.ToLower(ch.Priority)
+	if len(ch.Tags) > pushMaxTags {
+		return fmt.Errorf("Channel %s: at most %d tags are allowed", label, pushMaxTags)
+	}
+	for i, tag := range ch.Tags {
+		ch.Tags[i] = strings.TrimSpace(tag)
+		if !ntfyTagRegex.MatchString(ch.Tags[i]) {
+			return fmt.Errorf("Channel %s: tag %q should have only letters, numbers, -, _ and +", label, tag)
+		}
+	}
+	return nil
+}
+
+func validateGotifyChannel(ch *NotificationChannel, label string) error {
+	if ch.Token == "" {
+		return fmt.Errorf("Channel %s: application token is required", label)
+	}
+	if ch.Priority != "" {
+		if p, err := strconv.Atoi(ch.Priority); err != nil || p < 0 || p > 10 {
+			return fmt.Errorf("Channel %s: priority should be a number from 0 to 10", label)
+		}
+	}
+	ch.Tags = nil
+	return nil
+}
+
+// pushSummary is a line like "3 repos changed: rails/rails master v7.0.0, golang/go go1.22"
+func pushSummary(diffs gnDiffDatum) string {
+	var repos []string
+	for _, diff := range diffs {
+		if !diff.Changed {
+			continue
+		}
+		parts := []string{diff.Repo.Text}
+		for _, data := range diff.Data {
+			if !data.Changed || data.Error != "" {
+				continue
+			}
+			if data.ChangeType == "repoBranchDiff" {
+				parts = append(parts, data.Title.Text)
+				continue
+			}
+			for i, change := range data.Changes {
+				if i == pushMaxRefs {
+					parts = append(parts, fmt.Sprintf("+%d %s", len(data.Changes)-i, strings.ToLower(data.Title.Text)))
+					break
+				}
+				parts = append(parts, change.Text)
+			}
+		}
+		repos = append(repos, strings.Join(parts, " "))
+	}
+
+	noun := "repos"
+	if len(repos) == 1 {
+		noun = "repo"
+	}
+	summary := fmt.Sprintf("%d %s changed: %s", len(repos), noun, strings.Join(repos, ", "))
+	return truncateString(summary, pushMaxMessageSize)
+}
+
+// pushClickURL links to the changes saved for the run
+func pushClickURL(fileName string) string {
+	if fileName == "" {
+		return config.websiteURL() + "/changes"
+	}
+	return config.websiteURL() + "/changes/" + fileName
+}
+
+// ntfyRequests publishes the summary on the topic with the options sent as headers
+func ntfyRequests(diffs gnDiffDatum, ch *NotificationChannel, fileName string) ([]*deliveryRequest, error) {
+	req := &deliveryRequest{
+		Method: "POST",
+		URL:    strings.TrimRight(ch.Target, "/") + "/" + ch.Recipient,
+		Header: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+			"Title":        pushTitle,
+			"Click":        pushClickURL(fileName),
+		},
+		Body: pushSummary(diffs),
+	}
+	if ch.Priority != "" {
+		req.Header["Priority"] = ch.Priority
+	}
+	if len(ch.Tags) > 0 {
+		req.Header["Tags"] = strings.Join(ch.Tags, ",")
+	}
+	if ch.Token != "" {
+		// the tokens are added when the request is sent so that they are not saved with the delivery
+		req.Auth = deliveryAuthBearer
+	}
+	return []*deliveryRequest{req}, nil
+}
+
+// gotifyRequests creates a message for the application of the token
+func gotifyRequests(diffs gnDiffDatum, ch *NotificationChannel, fileName string) ([]*deliveryRequest, error) {
+	message := &GotifyMessage{
+		Title:   pushTitle,
+		Message: pushSummary(diffs),
+		Extras: map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": pushClickURL(fileName)},
+			},
+		},
+	}
+	if ch.Priority != "" {
+		p, _ := strconv.Atoi(ch.Priority)
+		message.Priority = &p
+	}
+	req, err := newJSONRequest("POST", strings.TrimRight(ch.Target, "/")+"/message", message)
+	if err != nil {
+		return nil, err
+	}
+	req.Auth = deliveryAuthGotifyKey
+	return []*deliveryRequest{req}, nil
+}
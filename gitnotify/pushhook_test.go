@@ -0,0 +1,133 @@
+package gitnotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateNtfyChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		topic    string
+		priority string
+		tags     []string
+		valid    bool
+	}{
+		{"topic", "git-updates_1", "", nil, true},
+		{"topic with slash", "git/updates", "", nil, false},
+		{"priority", "git", "High", nil, true},
+		{"unknown priority", "git", "6", nil, false},
+		{"tags", "git", "", []string{"warning", "+1", " rotating_light "}, true},
+		{"tag with comma", "git", "", []string{"a,b"}, false},
+		{"tag with newline", "git", "", []string{"a\r\nX-Injected: 1"}, false},
+		{"tag with space", "git", "", []string{"two words"}, false},
+		{"empty tag", "git", "", []string{""}, false},
+		{"too many tags", "git", "", []string{"a", "b", "c", "d", "e", "f"}, false},
+	}
+	for _, tt := range tests {
+		ch := &NotificationChannel{Recipient: tt.topic, Priority: tt.priority, Tags: tt.tags}
+		err := validateNtfyChannel(ch, "ntfy")
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+		}
+	}
+
+	ch := &NotificationChannel{Recipient: "git", Priority: "HIGH", Tags: []string{" warning "}}
+	validateNtfyChannel(ch, "ntfy")
+	if ch.Priority != "high" || ch.Tags[0] != "warning" {
+		t.Errorf("expected the priority and the tags to be cleaned, got %q %q", ch.Priority, ch.Tags)
+	}
+}
+
+func TestValidateGotifyChannel(t *testing.T) {
+	tests := []struct {
+		token, priority string
+		valid           bool
+	}{
+		{"token", "", true},
+		{"token", "10", true},
+		{"token", "11", false},
+		{"token", "high", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		err := validateGotifyChannel(&NotificationChannel{Token: tt.token, Priority: tt.priority, Tags: []string{"a"}}, "gotify")
+		if (err == nil) != tt.valid {
+			t.Errorf("%q %q: expected valid %v, got %v", tt.token, tt.priority, tt.valid, err)
+		}
+	}
+}
+
+func TestPushSummary(t *testing.T) {
+	tags := make([]link, 5)
+	for i := range tags {
+		tags[i] = link{Text: "v" + string('1'+rune(i))}
+	}
+	tests := []struct {
+		name    string
+		diffs   gnDiffDatum
+		summary string
+	}{
+		{"one repo", sampleDiff(), "1 repo changed: rails/rails v7.0.0"},
+		{"branch and refs", gnDiffDatum{
+			&gnDiffData{Repo: link{Text: "a/b"}, Changed: true, Data: []diffData{
+				{Title: link{Text: "master"}, ChangeType: "repoBranchDiff", Changed: true},
+				{Title: link{Text: "Tags"}, Changed: true, Changes: tags},
+				{Title: link{Text: "broken"}, Changed: true, Error: "not found"},
+			}},
+			&gnDiffData{Repo: link{Text: "c/d"}, Changed: true},
+			&gnDiffData{Repo: link{Text: "e/f"}, Changed: false},
+		}, "2 repos changed: a/b master v1 v2 v3 +2 tags, c/d"},
+	}
+	for _, tt := range tests {
+		if got := pushSummary(tt.diffs); got != tt.summary {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.summary, got)
+		}
+	}
+}
+
+// the tokens are read from the channel when the notification is sent, and are not saved with the delivery
+func TestPushTokensAddedWhenSent(t *testing.T) {
+	defer withDataDir(t)()
+	headers := make(map[string]http.Header)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.URL.Path] = r.Header
+	}))
+	defer server.Close()
+
+	conf, _, _ := testDelivery(server.URL)
+	channels := []*NotificationChannel{
+		{Name: "phone", Type: ntfyChannelType, Target: server.URL, Recipient: "git", Token: "tk_ntfy", Tags: []string{"warning", "git"}, Enabled: true},
+		{Name: "public", Type: ntfyChannelType, Target: server.URL, Recipient: "public", Enabled: true},
+		{Name: "desktop", Type: gotifyChannelType, Target: server.URL, Token: "gotify-app", Enabled: true},
+	}
+	saveTestSettings(t, conf, channels...)
+
+	for i, ch := range channels {
+		id := "1-p" + string('a'+rune(i))
+		reqs, err := channelRequests(sampleDiff(), conf, ch, id, "diff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = deliver(conf, ch, id, "diff", reqs); err != nil {
+			t.Fatal(err)
+		}
+		d, _ := findDelivery(conf, id)
+		saved, _ := readCompressedFile(d.fileName(deliveryDelivered))
+		if ch.Token != "" && strings.Contains(string(saved), ch.Token) {
+			t.Errorf("%s: expected the token not to be saved: %s", ch.Name, saved)
+		}
+	}
+
+	if h := headers["/git"]; h.Get("Authorization") != "Bearer tk_ntfy" || h.Get("Tags") != "warning,git" {
+		t.Errorf("unexpected ntfy headers %v", h)
+	}
+	if h := headers["/public"]; h.Get("Authorization") != "" {
+		t.Errorf("expected no Authorization without a token, got %v", h)
+	}
+	if h := headers["/message"]; h.Get("X-Gotify-Key") != "gotify-app" {
+		t.Errorf("unexpected gotify headers %v", h)
+	}
+}
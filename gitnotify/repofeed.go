@@ -0,0 +1,304 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Ref changes seen while processing any user are shared as public feeds
+//
+//	/feeds/<provider>/<owner>/<repo>.atom has every change of the repo
+//	/feeds/<provider>/<owner>/<repo>/<ref>.atom has the changes of a branch or tag
+//
+// They are stored at data/feeds/<provider>/<owner>/<repo>.json independent of the users
+// Only the repos which can be read without a token are recorded, to not expose private repos
+const (
+	repoFeedsDir       = "feeds"
+	repoFeedMaxEvents  = 200
+	publicRepoCheckTTL = 24 * time.Hour
+	// maximum size of the repo read from the API of the provider
+	publicRepoMaxSize = 1024 * 1024
+
+	refEventBranch = "branch"
+	refEventTag    = "tag"
+)
+
+// refEvent is a transition of a ref. OldCommit is empty for new branches and tags
+// NewCommit of a new branch/tag is its commit, so that a tag deleted and created again is a new event
+type refEvent struct {
+	Kind      string    `json:"kind"`
+	Ref       string    `json:"ref"`
+	OldCommit string    `json:"old_commit,omitempty"`
+	NewCommit string    `json:"new_commit,omitempty"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// repoFeed has the latest ref events of a repo, newest first
+type repoFeed struct {
+	Provider string      `json:"provider"`
+	Repo     string      `json:"repo"`
+	Events   []*refEvent `json:"events"`
+}
+
+// serialises the updates of the feed files from the cron jobs of the users
+var repoFeedMutex sync.Mutex
+
+// publicRepoChecks caches whether a repo is public for publicRepoCheckTTL
+var publicRepoChecks = struct {
+	sync.Mutex
+	checkedAt map[string]time.Time
+	public    map[string]bool
+}{checkedAt: make(map[string]time.Time), public: make(map[string]bool)}
+
+func (e *refEvent) key() string {
+	return strings.Join([]string{e.Kind, e.Ref, e.NewCommit}, ":")
+}
+
+// repoFeedFile returns the file of the repo feed or "" when the names are not safe to be used as a path
+func repoFeedFile(provider, repo string) string {
+	ownerRepo := strings.Split(repo, "/")
+	if config.Providers[provider] == "" || len(ownerRepo) != 2 || !isSafePathName(ownerRepo[0]) || !isSafePathName(ownerRepo[1]) {
+		return ""
+	}
+	return filepath.Join(config.DataDir, repoFeedsDir, provider, ownerRepo[0], ownerRepo[1]+".json")
+}
+
+func loadRepoFeed(provider, repo string) (*repoFeed, error) {
+	fileName := repoFeedFile(provider, repo)
+	if fileName == "" {
+		return nil, fmt.Errorf("invalid repo %s", repo)
+	}
+	data, err := readCompressedFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	feed := &repoFeed{}
+	if err = json.Unmarshal(data, feed); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (f *repoFeed) save() error {
+	fileName := repoFeedFile(f.Provider, f.Repo)
+	if fileName == "" {
+		return fmt.Errorf("invalid repo %s", f.Repo)
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	out, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return saveCompressedFile(fileName, out)
+}
+
+// add prepends the events which were not seen by another user. Returns true when the feed changed
+func (f *repoFeed) add(events []*refEvent) bool {
+	seen := make(map[string]bool, len(f.Events))
+	for _, e := range f.Events {
+		seen[e.key()] = true
+	}
+	var fresh []*refEvent
+	for _, e := range events {
+		if !seen[e.key()] {
+			seen[e.key()] = true
+			fresh = append(fresh, e)
+		}
+	}
+	if len(fresh) == 0 {
+		return false
+	}
+	f.Events = append(fresh, f.Events...)
+	if len(f.Events) > repoFeedMaxEvents {
+		f.Events = f.Events[:repoFeedMaxEvents]
+	}
+	return true
+}
+
+// refEvents converts the diff of a repo computed for a user into ref transitions
+func refEvents(diff *gitRepoDiffs, seenAt time.Time) []*refEvent {
+	var events []*refEvent
+	for ref, commit := range diff.References {
+		// commits seen for the first time or missing branches are not transitions
+		if commit.OldCommit == "" || commit.NewCommit == noneString || !commit.changed() {
+			continue
+		}
+		events = append(events, &refEvent{
+			Kind:      refEventBranch,
+			Ref:       ref,
+			OldCommit: commit.OldCommit,
+			NewCommit: commit.NewCommit,
+			SeenAt:    seenAt,
+		})
+	}
+	for _, refList := range diff.RefList {
+		// every ref is listed the first time the repo is fetched for a user
+		if refList.Initial {
+			continue
+		}
+		kind := refEventBranch
+		if refList.Title == "Tags" {
+			kind = refEventTag
+		}
+		for _, ref := range refList.References {
+			events = append(events, &refEvent{Kind: kind, Ref: ref, NewCommit: refList.Commits[ref], SeenAt: seenAt})
+		}
+	}
+	return events
+}
+
+// recordRefChanges adds the transitions seen for a user into the shared feeds of the public repos
+func recordRefChanges(provider string, repoDiffs []*gitRepoDiffs) {
+	now := time.Now().UTC()
+	for _, diff := range repoDiffs {
+		events := refEvents(diff, now)
+		if len(events) == 0 || repoFeedFile(provider, diff.RepoName) == "" || !isPublicRepo(provider, diff.RepoName) {
+			continue
+		}
+
+		repoFeedMutex.Lock()
+		feed, err := loadRepoFeed(provider, diff.RepoName)
+		if err != nil {
+			feed = &repoFeed{Provider: provider, Repo: diff.RepoName}
+		}
+		if feed.add(events) {
+			if err = feed.save(); err != nil {
+				log.Printf("Error saving feed of %s/%s: %s", provider, diff.RepoName, err)
+			}
+		}
+		repoFeedMutex.Unlock()
+	}
+}
+
+// isPublicRepo checks that the repo can be read without the token of the user
+func isPublicRepo(provider, repo string) bool {
+	key := provider + "/" + repo
+	publicRepoChecks.Lock()
+	checkedAt, ok := publicRepoChecks.checkedAt[key]
+	public := publicRepoChecks.public[key]
+	publicRepoChecks.Unlock()
+	if ok && time.Since(checkedAt) < publicRepoCheckTTL {
+		return public
+	}
+
+	public, err := fetchIsPublicRepo(provider, repo)
+	if err != nil {
+		// checked again with the next change of the repo
+		log.Printf("Error checking if %s is public: %s", key, err)
+		return false
+	}
+
+	publicRepoChecks.Lock()
+	publicRepoChecks.checkedAt[key] = time.Now()
+	publicRepoChecks.public[key] = public
+	publicRepoChecks.Unlock()
+	return public
+}
+
+// publicRepoClient reads the repos from the API of the providers without a token
+var publicRepoClient = &http.Client{Timeout: 10 * time.Second}
+
+// publicRepoURL is the API url of the repo. It is readable without a token only when the repo is public
+func publicRepoURL(provider, repo string) string {
+	switch {
+	case provider == GithubProvider && config.GithubAPIEndPoint != "":
+		return config.GithubAPIEndPoint + "repos/" + repo
+	case provider == GitlabProvider && config.GitlabAPIEndPoint != "":
+		return strings.TrimRight(config.GitlabAPIEndPoint, "/") + "/projects/" + url.PathEscape(repo)
+	}
+	return ""
+}
+
+// fetchIsPublicRepo requests the repo without a token. GitHub has "private" and GitLab has "visibility"
+func fetchIsPublicRepo(provider, repo string) (bool, error) {
+	apiURL := publicRepoURL(provider, repo)
+	if apiURL == "" {
+		return false, nil
+	}
+	resp, err := publicRepoClient.Get(apiURL)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("responded with %s", resp.Status)
+	}
+
+	var project struct {
+		Private    bool   `json:"private"`
+		Visibility string `json:"visibility"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, publicRepoMaxSize)).Decode(&project); err != nil {
+		return false, err
+	}
+	return !project.Private && (project.Visibility == "" || project.Visibility == "public"), nil
+}
+
+// feedEntry converts the event into an entry of the feed
+func (e *refEvent) feedEntry(provider, repo string) *feedEntry {
+	entry := &feedEntry{
+		ID:      fmt.Sprintf("%s/feeds/%s/%s#%s", config.websiteURL(), provider, repo, e.key()),
+		Updated: e.SeenAt,
+	}
+	if e.OldCommit == "" {
+		entry.Title = fmt.Sprintf("%s: new %s %s", repo, e.Kind, e.Ref)
+		entry.Link = TreeLink(provider, repo, e.Ref)
+		entry.Content = fmt.Sprintf("New %s <a href=\"%s\">%s</a> in %s",
+			e.Kind, html.EscapeString(entry.Link), html.EscapeString(e.Ref), html.EscapeString(repo))
+	} else {
+		entry.Title = fmt.Sprintf("%s: %s updated to %s", repo, e.Ref, shortCommit(e.NewCommit))
+		entry.Link = CompareLink(provider, repo, e.OldCommit, e.NewCommit)
+		entry.Content = fmt.Sprintf("%s of %s changed <a href=\"%s\">%s..%s</a>",
+			html.EscapeString(e.Ref), html.EscapeString(repo), html.EscapeString(entry.Link), shortCommit(e.OldCommit), shortCommit(e.NewCommit))
+	}
+	entry.Summary = entry.Title
+	return entry
+}
+
+// repoFeedHandler serves the public feed of a repo or of one of its refs
+func repoFeedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provider := vars["provider"]
+	repo := vars["owner"] + "/" + vars["repo"]
+	ref := vars["ref"]
+
+	feed, err := loadRepoFeed(provider, repo)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	title := "Changes in " + repo
+	selfURL := fmt.Sprintf("%s/feeds/%s/%s.%s", config.websiteURL(), provider, repo, feedAtom)
+	alternateURL := RepoLink(provider, repo)
+	if ref != "" {
+		title = fmt.Sprintf("Changes in %s of %s", ref, repo)
+		selfURL = fmt.Sprintf("%s/feeds/%s/%s/%s.%s", config.websiteURL(), provider, repo, ref, feedAtom)
+		alternateURL = TreeLink(provider, repo, ref)
+	}
+
+	var entries []*feedEntry
+	for _, e := range feed.Events {
+		if ref == "" || e.Ref == ref {
+			entries = append(entries, e.feedEntry(provider, repo))
+		}
+	}
+	writeFeed(w, feedAtom, title, selfURL, alternateURL, entries)
+}
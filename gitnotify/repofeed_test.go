@@ -0,0 +1,180 @@
+package gitnotify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		diff   *gitRepoDiffs
+		events []string
+	}{
+		{"updated branch", &gitRepoDiffs{References: map[string]*gitCommitDiff{
+			"master":  {OldCommit: "a1", NewCommit: "b2"},
+			"same":    {OldCommit: "a1", NewCommit: "a1"},
+			"first":   {OldCommit: "", NewCommit: "c3"},
+			"removed": {OldCommit: "a1", NewCommit: noneString},
+		}}, []string{"branch:master:b2"}},
+		{"new refs", &gitRepoDiffs{RefList: []*gitRefList{
+			{Title: "Branches", References: []string{"feature"}, Commits: map[string]string{"feature": "f1"}},
+			{Title: "Tags", References: []string{"v1.0", "v1.1"}, Commits: map[string]string{"v1.0": "t1", "v1.1": "t2"}},
+		}}, []string{"branch:feature:f1", "tag:v1.0:t1", "tag:v1.1:t2"}},
+		{"first run of the repo", &gitRepoDiffs{RefList: []*gitRefList{
+			{Title: "Branches", References: []string{"master", "develop"}, Initial: true},
+			{Title: "Tags", References: []string{"v1.0"}, Initial: true},
+		}}, nil},
+	}
+	seenAt := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		var keys []string
+		for _, e := range refEvents(tt.diff, seenAt) {
+			if !e.SeenAt.Equal(seenAt) {
+				t.Errorf("%s: unexpected seen at %s", tt.name, e.SeenAt)
+			}
+			keys = append(keys, e.key())
+		}
+		sort.Strings(keys)
+		if strings.Join(keys, ",") != strings.Join(tt.events, ",") {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.events, keys)
+		}
+	}
+}
+
+func TestRepoFeedAdd(t *testing.T) {
+	tag := func(ref, commit string) *refEvent {
+		return &refEvent{Kind: refEventTag, Ref: ref, NewCommit: commit}
+	}
+	tests := []struct {
+		name    string
+		events  []*refEvent
+		add     []*refEvent
+		changed bool
+		keys    []string
+	}{
+		{"empty feed", nil, []*refEvent{tag("v1", "a")}, true, []string{"tag:v1:a"}},
+		{"seen by another user", []*refEvent{tag("v1", "a")}, []*refEvent{tag("v1", "a")}, false, []string{"tag:v1:a"}},
+		{"recreated at another commit", []*refEvent{tag("v1", "a")}, []*refEvent{tag("v1", "b")}, true, []string{"tag:v1:b", "tag:v1:a"}},
+		{"duplicates in the same run", nil, []*refEvent{tag("v1", "a"), tag("v1", "a"), tag("v2", "b")}, true, []string{"tag:v1:a", "tag:v2:b"}},
+	}
+	for _, tt := range tests {
+		feed := &repoFeed{Events: tt.events}
+		if changed := feed.add(tt.add); changed != tt.changed {
+			t.Errorf("%s: expected changed %v, got %v", tt.name, tt.changed, changed)
+		}
+		var keys []string
+		for _, e := range feed.Events {
+			keys = append(keys, e.key())
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.keys, keys)
+		}
+	}
+
+	feed := &repoFeed{}
+	for i := 0; i < repoFeedMaxEvents+10; i++ {
+		feed.add([]*refEvent{tag(fmt.Sprintf("v%d", i), "a")})
+	}
+	if len(feed.Events) != repoFeedMaxEvents || feed.Events[0].Ref != fmt.Sprintf("v%d", repoFeedMaxEvents+9) {
+		t.Errorf("expected the newest %d events, got %d starting with %s", repoFeedMaxEvents, len(feed.Events), feed.Events[0].Ref)
+	}
+}
+
+func TestRefCommits(t *testing.T) {
+	refs := []*GitRefWithCommit{{Name: "master", Commit: "a1"}, {Name: "v1", Commit: "b2"}, {Name: "v2", Commit: "c3"}}
+	commits := refCommits(refs, []string{"v1", "v2", "missing"})
+	if len(commits) != 2 || commits["v1"] != "b2" || commits["v2"] != "c3" {
+		t.Errorf("unexpected commits %v", commits)
+	}
+}
+
+// fakeProviderAPI serves the repos of GitHub and GitLab for the requests without a token
+func fakeProviderAPI(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("expected no token in the request of %s", r.URL)
+		}
+		switch r.URL.EscapedPath() {
+		case "/github/repos/rails/rails":
+			w.Write([]byte(`{"full_name": "rails/rails", "private": false}`))
+		case "/github/repos/acme/internal":
+			w.Write([]byte(`{"full_name": "acme/internal", "private": true}`))
+		case "/github/repos/acme/flaky":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/gitlab/projects/gitlab-org%2Fgitlab":
+			w.Write([]byte(`{"path_with_namespace": "gitlab-org/gitlab", "visibility": "public"}`))
+		case "/gitlab/projects/acme%2Finternal":
+			w.Write([]byte(`{"path_with_namespace": "acme/internal", "visibility": "internal"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestIsPublicRepo(t *testing.T) {
+	server := fakeProviderAPI(t)
+	defer server.Close()
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{GithubAPIEndPoint: server.URL + "/github/", GitlabAPIEndPoint: server.URL + "/gitlab/"}
+
+	tests := []struct {
+		provider, repo string
+		public         bool
+	}{
+		{GithubProvider, "rails/rails", true},
+		{GithubProvider, "acme/internal", false},
+		{GithubProvider, "acme/secret", false},
+		{GithubProvider, "acme/flaky", false},
+		{GitlabProvider, "gitlab-org/gitlab", true},
+		{GitlabProvider, "acme/internal", false},
+		{"bitbucket", "rails/rails", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicRepo(tt.provider, tt.repo); got != tt.public {
+			t.Errorf("%s/%s: expected %v, got %v", tt.provider, tt.repo, tt.public, got)
+		}
+	}
+	publicRepoChecks.Lock()
+	_, cached := publicRepoChecks.checkedAt[GithubProvider+"/acme/flaky"]
+	publicRepoChecks.Unlock()
+	if cached {
+		t.Error("expected a failed check not to be cached")
+	}
+}
+
+func TestRecordRefChanges(t *testing.T) {
+	defer withDataDir(t)()
+	server := fakeProviderAPI(t)
+	defer server.Close()
+	config.GithubAPIEndPoint = server.URL + "/github/"
+	config.Providers = map[string]string{GithubProvider: "GitHub"}
+
+	newTag := func(repo string) *gitRepoDiffs {
+		return &gitRepoDiffs{RepoName: repo, RefList: []*gitRefList{
+			{Title: "Tags", References: []string{"v1.0"}, Commits: map[string]string{"v1.0": "t1"}},
+		}}
+	}
+	recordRefChanges(GithubProvider, []*gitRepoDiffs{newTag("rails/rails"), newTag("acme/internal"), newTag("acme/secret")})
+
+	tests := []struct {
+		repo     string
+		recorded bool
+	}{
+		{"rails/rails", true},
+		{"acme/internal", false},
+		{"acme/secret", false},
+	}
+	for _, tt := range tests {
+		feed, err := loadRepoFeed(GithubProvider, tt.repo)
+		if recorded := err == nil && len(feed.Events) == 1; recorded != tt.recorded {
+			t.Errorf("%s: expected the feed to be recorded %v, got %v", tt.repo, tt.recorded, err)
+		}
+	}
+}
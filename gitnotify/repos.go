@@ -136,6 +136,7 @@ func settingsHandler(w http.ResponseWriter, r *http.Request, formAction string)
 	userInfo := getUserInfo(hc)
 	configFile := userInfo.getConfigFile()
 
+	defer lockSetting(configFile)()
 	conf := new(Setting)
 	conf.load(configFile)
 
@@ -187,6 +188,7 @@ func actOnOrgs(hc *kinli.HttpContext, formAction string, r *http.Request, conf *
 			orgName,
 			orgType,
 			provider,
+			conf.cleanRoutes(r.Form["channels"]),
 		}
 
 		// TODO move method under repo/settings struct
@@ -266,6 +268,7 @@ func actOnRepos(hc *kinli.HttpContext, formAction string, r *http.Request, conf
 			contains(r.Form["branches"], "true"),
 			contains(r.Form["tags"], "true"),
 			provider,
+			conf.cleanRoutes(r.Form["channels"]),
 		}
 
 		// TODO move method under repo/settings struct
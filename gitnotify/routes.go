@@ -40,9 +40,18 @@ func InitRouter() {
 	// POST is responsible for create, update and delete
 	r.HandleFunc("/", settingsSaveHandler).Methods("POST")
 	r.HandleFunc("/run", forceRunHandler).Methods("POST")
+	r.HandleFunc("/preview", previewHandler).Methods("GET")
 
 	r.HandleFunc("/user", userSettingsShowHandler).Methods("GET")
 	r.HandleFunc("/user", userSettingsSaveHandler).Methods("POST")
+	r.HandleFunc("/user/tokens", apiTokenCreateHandler).Methods("POST")
+	r.HandleFunc("/user/tokens/delete", apiTokenDeleteHandler).Methods("POST")
+	r.HandleFunc("/user/feed", feedTokenHandler).Methods("POST")
+	r.HandleFunc("/user/verify-email", emailVerificationResendHandler).Methods("POST")
+	r.HandleFunc("/verify-email/{token}", emailVerificationHandler).Methods("GET")
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	initAPI(api)
 
 	r.HandleFunc("/typeahead/repo", newCacheHandler(repoTypeAheadHandler)).Methods("GET")
 	r.HandleFunc("/typeahead/branch", newCacheHandler(branchTypeAheadHandler)).Methods("GET")
@@ -52,6 +61,17 @@ func InitRouter() {
 	r.HandleFunc("/changes/", listAllDiffs).Methods("GET")
 	r.HandleFunc("/changes/{diffentry}", renderThisDiff).Methods("GET")
 
+	r.HandleFunc("/feed/{token}.{format:atom|rss}", userFeedHandler).Methods("GET")
+	r.HandleFunc("/feeds/{provider}/{owner}/{repo}.atom", repoFeedHandler).Methods("GET")
+	r.HandleFunc("/feeds/{provider}/{owner}/{repo}/{ref:.+}.atom", repoFeedHandler).Methods("GET")
+
+	r.HandleFunc("/unsubscribe/{token}", unsubscribeShowHandler).Methods("GET")
+	r.HandleFunc("/unsubscribe/{token}", unsubscribeHandler).Methods("POST")
+	r.HandleFunc("/bounces/ses", sesBounceHandler).Methods("POST")
+
+	r.HandleFunc("/deliveries", deliveriesHandler).Methods("GET")
+	r.HandleFunc("/deliveries/{id}/redeliver", redeliverHandler).Methods("POST")
+
 	r.HandleFunc("/logout", func(res http.ResponseWriter, req *http.Request) {
 		hc := &kinli.HttpContext{W: res, R: req}
 		hc.ClearSession()
@@ -73,6 +73,8 @@ func (e *gitRepoDiffs) String() string {
 type gitRefList struct {
 	Title      string
 	References []string
+	Commits    map[string]string // commit of each of the References, used by the repo feeds
+	Initial    bool              // the repo had no information before, so the references existed already
 }
 
 func (e *gitRefList) String() string {
@@ -144,11 +146,6 @@ func getData(provider string) {
 }
 
 func processRepoDiffs(conf *Setting) (allLocalDiffs []*gitRepoDiffs, err error) {
-	if !hasUserNotificationSet(conf) {
-		log.Printf("No email address for %s\n", conf.Auth.UserName)
-		return nil, &userNotFound{}
-	}
-
 	client := getGitClient(conf.Auth.Provider, conf.Auth.Token)
 	branch := &gitBranchList{}
 
@@ -161,6 +158,7 @@ func processRepoDiffs(conf *Setting) (allLocalDiffs []*gitRepoDiffs, err error)
 			Provider: conf.Auth.Provider,
 		}
 		allLocalDiffs = append(allLocalDiffs, localDiffs)
+		initial := conf.Info[repo.Repo] == nil
 
 		// branch is reused here without creating new ones
 		branch.repo = repo
@@ -200,6 +198,8 @@ func processRepoDiffs(conf *Setting) (allLocalDiffs []*gitRepoDiffs, err error)
 				l := &gitRefList{
 					Title:      "Branches",
 					References: branchesDiff,
+					Commits:    refCommits(newBranches, branchesDiff),
+					Initial:    initial,
 				}
 				localDiffs.RefList = append(localDiffs.RefList, l)
 			}
@@ -211,6 +211,8 @@ func processRepoDiffs(conf *Setting) (allLocalDiffs []*gitRepoDiffs, err error)
 			l := &gitRefList{
 				Title:      "Tags",
 				References: tagsDiff,
+				Commits:    refCommits(newTags, tagsDiff),
+				Initial:    initial,
 			}
 			localDiffs.RefList = append(localDiffs.RefList, l)
 		}
@@ -300,33 +302,68 @@ func processDiffForUser(conf *Setting) {
 		return
 	}
 
-	orgDiffs, err := processOrgDiffs(conf)
+	if !hasUserNotificationSet(conf) {
+		log.Printf("Failure processing %s/%s, %s\n", conf.Auth.Provider, conf.Auth.UserName, &userNotFound{})
+		return
+	}
 
-	repoDiff, err := processRepoDiffs(conf)
+	// the user could have unsubscribed after the cron was started
+	if conf.User.Disabled {
+		log.Printf("Not processing conf %s/%s since notifications are disabled", conf.Auth.Provider, conf.Auth.UserName)
+		return
+	}
+
+	diffs, repoDiffs, err := computeDiffs(conf)
 	if err != nil {
 		log.Printf("Failure processing %s/%s, %s\n", conf.Auth.Provider, conf.Auth.UserName, err)
 		return
 	}
 
+	// save to new file based on hour/date
+	fileName, err := diffs.save(conf)
+	if err != nil {
+		fileName = ""
+	}
+
+	notifyForUser(diffs, conf, fileName)
+	// the shared feeds are updated after the user is notified, so that they cannot delay or stop the notifications
+	recordRefChanges(conf.Auth.Provider, repoDiffs)
+}
+
+// computeDiffForUser fetches the latest information from the remote and diffs it with conf.Info
+// conf.Info is updated in memory and it is upto the caller to persist it
+func computeDiffForUser(conf *Setting) (gnDiffDatum, error) {
+	diffs, _, err := computeDiffs(conf)
+	return diffs, err
+}
+
+// computeDiffs is computeDiffForUser along with the ref changes of the repos, which are shared in the repo feeds
+func computeDiffs(conf *Setting) (gnDiffDatum, []*gitRepoDiffs, error) {
+	orgDiffs, err := processOrgDiffs(conf)
+
+	repoDiff, err := processRepoDiffs(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	repoDiffs := makeRepoDiffs(repoDiff, conf)
 
 	var diffs gnDiffDatum
 	diffs = append(diffs, repoDiffs...)
 	diffs = append(diffs, orgDiffs...)
 
-	// save to new file based on hour/date
-	fileName, err := diffs.save(conf)
-	if err != nil {
-		fileName = ""
-	}
+	return diffs, repoDiff, nil
+}
 
+// notifyForUser sends the diff through all the notification mechanisms when there are changes
+func notifyForUser(diffs gnDiffDatum, conf *Setting, fileName string) {
 	if eligible := diffs.hasChanges(); !eligible {
 		log.Printf("No changes. Skipping Notifications")
 		return
 	}
 
-	processForMail(diffs, conf, fileName)
-	processForWebhook(diffs, conf)
+	processForMail(conf.diffsForChannel(diffs, conf.primaryEmail()), conf, fileName)
+	processForWebhook(diffs, conf, fileName)
 }
 
 // option can be tags or branches
@@ -409,6 +446,17 @@ func processOrgDiffs(conf *Setting) (gnDiffDatum, error) {
 
 }
 
+// refCommits returns the commits of the named refs
+func refCommits(refs []*GitRefWithCommit, names []string) map[string]string {
+	commits := make(map[string]string, len(names))
+	for _, ref := range refs {
+		if StringIn(names, ref.Name) {
+			commits[ref.Name] = ref.Commit
+		}
+	}
+	return commits
+}
+
 // FIXME
 func diffWithOldBranches(v []*GitRefWithCommit, branch *gitBranchList, option string, info map[string]*Information) []string {
 	newBranches := make([]string, len(v))
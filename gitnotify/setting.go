@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -18,6 +19,10 @@ type Setting struct {
 	Auth    *Authentication         `yaml:"auth"`
 	User    *UserNotification       `yaml:"user_notification"`
 	Info    map[string]*Information `yaml:"fetched_info"`
+
+	APITokens     []*APIToken `yaml:"api_tokens,omitempty"`
+	FeedTokenHash string      `yaml:"feed_token_hash,omitempty"` // sha256 of the secret in the url of the feed of changes
+	FeedToken     string      `yaml:"feed_token,omitempty"`      // secret saved by earlier versions, replaced by FeedTokenHash on load
 }
 
 func (c *Setting) usersEmail() string {
@@ -35,24 +40,27 @@ func (c *Setting) usersName() string {
 }
 
 func (c *Setting) anyValidNotifications() bool {
-	return config.isEmailSetup() || c.User.isValidWebhook()
+	return config.isEmailSetup() || c.User.hasValidChannel()
 }
 
 // UserNotification is the customization/scheduling is provided for user
-// NOTE: this can be an array of notifications like email, Webhook options.
-// not gonna make the change to the array
+// Email is the primary notification, other destinations are in the list of Channels
 type UserNotification struct {
-	Email     string `yaml:"email"`
-	Name      string `yaml:"name"`
-	Disabled  bool   `yaml:"disabled"`
-	Frequency `yaml:",inline"`
+	Email        string `yaml:"email"`
+	PendingEmail string `yaml:"pending_email,omitempty"` // changed address which is used once the link emailed to it is opened
+	Name         string `yaml:"name"`
+	Disabled     bool   `yaml:"disabled"`
+	Frequency    `yaml:",inline"`
 
-	WebhookURL  string `yaml:"webhook_url"`
-	WebhookType string `yaml:"webhook_type"`
-}
+	PerRepoEmails bool `yaml:"per_repo_emails,omitempty"` // sends an email for each repo, threaded by the repo
+
+	InvalidEmails []*InvalidEmail `yaml:"invalid_emails,omitempty"` // addresses which bounced or complained are not emailed
+
+	Channels []*NotificationChannel `yaml:"channels,omitempty"`
 
-func (u *UserNotification) isValidWebhook() bool {
-	return StringIn(config.WebhookIntegrations, u.WebhookType) && u.WebhookURL != ""
+	// single webhook supported earlier. migrated into Channels when the settings are loaded
+	WebhookURL  string `yaml:"webhook_url,omitempty"`
+	WebhookType string `yaml:"webhook_type,omitempty"`
 }
 
 // Frequency is the cron format along with a TimeZone to process
@@ -141,6 +149,7 @@ type Organisation struct {
 	Name     string `yaml:"name"`
 	Type     string `yaml:"type"`
 	Provider string
+	Channels []string `yaml:"channels,omitempty,flow"` // names of the channels to notify. empty uses the defaults
 }
 
 // Repo is a repository that is being tracked
@@ -150,6 +159,7 @@ type Repo struct {
 	Branches        bool        `yaml:"new_branches"`
 	Tags            bool        `yaml:"new_tags"`
 	Provider        string
+	Channels        []string `yaml:"channels,omitempty,flow"` // names of the channels to notify. empty uses the defaults
 }
 type reference string
 
@@ -182,7 +192,7 @@ func (c *Setting) load(settingFile string) error {
 	err = yaml.Unmarshal(data, c)
 
 	// set provider at repo level
-	if c.Auth.Provider != "" {
+	if c.Auth != nil && c.Auth.Provider != "" {
 		for _, repo := range c.Repos {
 			repo.Provider = c.Auth.Provider
 		}
@@ -198,10 +208,33 @@ func (c *Setting) load(settingFile string) error {
 	if c.User == nil {
 		c.User = new(UserNotification)
 	}
+	c.User.migrateWebhook()
+	c.migrateFeedToken()
 
 	return nil
 }
 
+// settingLocks serialise the changes to a settings file, from its load to its save, by the cron, the bounces,
+// the unsubscribes, the confirmations, the API and the handlers of the user settings in the process
+var settingLocks = struct {
+	sync.Mutex
+	files map[string]*sync.Mutex
+}{files: make(map[string]*sync.Mutex)}
+
+// lockSetting locks the settings file until the returned func is called
+func lockSetting(settingFile string) func() {
+	settingLocks.Lock()
+	lock := settingLocks.files[settingFile]
+	if lock == nil {
+		lock = new(sync.Mutex)
+		settingLocks.files[settingFile] = lock
+	}
+	settingLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
 // persists setting into file
 func (c *Setting) save(settingFile string) error {
 	out, err := yaml.Marshal(c)
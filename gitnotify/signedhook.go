@@ -0,0 +1,59 @@
+package gitnotify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Generic webhook deliveries carry headers similar to the webhooks of GitHub
+//
+//	X-Gitnotify-Event: changes
+//	X-Gitnotify-Delivery: unique id of the delivery
+//	X-Gitnotify-Timestamp: unix time the request was sent at
+//	X-Gitnotify-Signature-256: sha256=<hex of HMAC-SHA256 of the body using the channel secret>
+//
+// The signature is sent only when the channel has a secret and is computed like X-Hub-Signature-256 of GitHub,
+// so the same code verifies both. The timestamp is not signed. The timestamp and the signature are added
+// each time the request is sent, using the secret saved with the channel at that time.
+// Receivers can use the delivery id to ignore the requests they received earlier
+const (
+	webhookEventHeader     = "X-Gitnotify-Event"
+	webhookDeliveryHeader  = "X-Gitnotify-Delivery"
+	webhookTimestampHeader = "X-Gitnotify-Timestamp"
+	webhookSignatureHeader = "X-Gitnotify-Signature-256"
+
+	webhookEventChanges = "changes"
+)
+
+// webhookSignature is the value of the signature header for the body
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedWebhookRequest POSTs the data with the delivery headers. It is signed when it is sent
+func signedWebhookRequest(url, event, deliveryID string, data interface{}) (*deliveryRequest, error) {
+	req, err := newJSONRequest("POST", url, data)
+	if err != nil {
+		return nil, err
+	}
+	req.Header["User-Agent"] = "gitnotify-webhook"
+	req.Header[webhookEventHeader] = event
+	req.Header[webhookDeliveryHeader] = deliveryID
+	req.Auth = deliveryAuthSignature
+	return req, nil
+}
+
+// signWebhookRequest sets the timestamp and the signature when a secret is present
+func signWebhookRequest(header http.Header, secret string, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header.Set(webhookTimestampHeader, timestamp)
+	if secret != "" {
+		header.Set(webhookSignatureHeader, webhookSignature(secret, body))
+	}
+}
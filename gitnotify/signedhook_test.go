@@ -0,0 +1,121 @@
+package gitnotify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	tests := []struct {
+		secret, body, signature string
+	}{
+		// the example in the docs of GitHub on validating webhook deliveries
+		{"It's a Secret to Everybody", "Hello, World!", "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"},
+		{"secret", `{"a":1}`, "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"},
+		{"other", `{"a":1}`, "sha256=6d5318f92e5561f77c1b686ef4a062a4a6504dcd982edac901b8e395de2db6e4"},
+		{"secret", "", "sha256=f9e66e179b6747ae54108f82f8ade8b3c25d76fd30afde6c395822c530196169"},
+	}
+	for _, tt := range tests {
+		if got := webhookSignature(tt.secret, []byte(tt.body)); got != tt.signature {
+			t.Errorf("%s %q: expected %s, got %s", tt.secret, tt.body, tt.signature, got)
+		}
+	}
+}
+
+func TestSignWebhookRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+	}{
+		{"with secret", "secret", "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"},
+		{"without secret", "", ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		signWebhookRequest(header, tt.secret, []byte(`{"a":1}`), now)
+		if got := header.Get(webhookTimestampHeader); got != "1700000000" {
+			t.Errorf("%s: expected the timestamp 1700000000, got %s", tt.name, got)
+		}
+		if got := header.Get(webhookSignatureHeader); got != tt.signature {
+			t.Errorf("%s: expected the signature %q, got %q", tt.name, tt.signature, got)
+		}
+	}
+}
+
+// the timestamp and the signature are computed when the request is sent, and are not saved with the delivery
+func TestWebhookSignedOnEachAttempt(t *testing.T) {
+	defer withDataDir(t)()
+	type received struct{ timestamp, signature, body string }
+	var requests []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, received{r.Header.Get(webhookTimestampHeader), r.Header.Get(webhookSignatureHeader), string(body)})
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	conf, ch, _ := testDelivery(server.URL)
+	ch.Secret = "s3cret-value"
+	saveTestSettings(t, conf, ch)
+
+	reqs, err := genericRequests(gnDiffDatum{}, ch, "1-s", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqs[0].Header[webhookTimestampHeader] != "" || reqs[0].Header[webhookSignatureHeader] != "" {
+		t.Fatalf("expected the request not to be signed when rendered: %v", reqs[0].Header)
+	}
+	if err = deliver(conf, ch, "1-s", "diff", reqs); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+
+	d, err := findDelivery(conf, "1-s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := readCompressedFile(d.fileName(deliveryPending))
+	if strings.Contains(string(saved), ch.Secret) || strings.Contains(string(saved), "sha256=") {
+		t.Fatalf("expected the secret and the signature not to be saved: %s", saved)
+	}
+
+	// the secret is read from the settings for the retry
+	d.NextAttemptAt = time.Now().Add(-time.Minute)
+	d.save()
+	time.Sleep(time.Second)
+	retryDeliveries()
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	if requests[0].timestamp == requests[1].timestamp {
+		t.Errorf("expected the retry to have a new timestamp, got %s twice", requests[0].timestamp)
+	}
+	for i, r := range requests {
+		if _, err := strconv.ParseInt(r.timestamp, 10, 64); err != nil {
+			t.Errorf("request %d: invalid timestamp %q", i, r.timestamp)
+		}
+		if expected := webhookSignature(ch.Secret, []byte(r.body)); r.signature != expected {
+			t.Errorf("request %d: expected the signature %s, got %s", i, expected, r.signature)
+		}
+	}
+}
+
+func saveTestSettings(t *testing.T, conf *Setting, channels ...*NotificationChannel) {
+	conf.User.Channels = channels
+	if err := os.MkdirAll(conf.Auth.getConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.save(conf.Auth.getConfigFile()); err != nil {
+		t.Fatal(err)
+	}
+}
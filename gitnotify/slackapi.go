@@ -0,0 +1,58 @@
+package gitnotify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Format of the slack channel decides how the messages are grouped
+//
+//	"" posts a message per repo on the incoming webhook at Target
+//	digest posts all the repos in a single message on the incoming webhook at Target
+//	threaded posts a summary using the Web API with the bot Token on the Recipient channel
+//	and replies with the details of each repo in its thread
+const (
+	slackDigest   = "digest"
+	slackThreaded = "threaded"
+)
+
+const slackAPIURL = "https://slack.com/api"
+
+func validateSlackChannel(ch *NotificationChannel, label string) error {
+	if !StringIn(ChannelFormats()["slack"], ch.Format) {
+		return fmt.Errorf("Channel %s: format should be one of %s", label, strings.Join(ChannelFormats()["slack"], ", "))
+	}
+	if ch.Format != slackThreaded {
+		return nil
+	}
+	if ch.Recipient == "" {
+		return fmt.Errorf("Channel %s: slack channel ID is required for threads", label)
+	}
+	if !strings.HasPrefix(ch.Token, "xoxb-") {
+		return fmt.Errorf("Channel %s: a bot token starting with xoxb- is required for threads", label)
+	}
+	return nil
+}
+
+// slackThreadRequests posts the summary of the changes and replies to it with the changes of each repo
+func slackThreadRequests(diffs gnDiffDatum, ch *NotificationChannel, fileName string) ([]*deliveryRequest, error) {
+	apiURL := strings.TrimRight(ch.Target, "/") + "/chat.postMessage"
+
+	parent := slackSummaryMessage(diffs, fileName)
+	messages := append([]*SlackMessage{parent}, slackMessages(diffs, "")...)
+
+	requests := make([]*deliveryRequest, 0, len(messages))
+	for i, message := range messages {
+		message.Channel = ch.Recipient
+		req, err := newJSONRequest("POST", apiURL, message)
+		if err != nil {
+			return nil, err
+		}
+		req.Header["Content-Type"] = "application/json; charset=utf-8"
+		req.Auth = deliveryAuthBearer
+		req.ExpectOK = true
+		req.InThread = i > 0
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
@@ -0,0 +1,97 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSlackThreadRequests(t *testing.T) {
+	ch := &NotificationChannel{Name: "thread", Type: "slack", Target: slackAPIURL + "/", Recipient: "C123", Token: "xoxb-secret", Format: slackThreaded}
+	reqs, err := slackThreadRequests(sampleDiff(), ch, "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("expected the summary and a reply per repo, got %d requests", len(reqs))
+	}
+	for i, req := range reqs {
+		if req.URL != slackAPIURL+"/chat.postMessage" || !req.ExpectOK || req.InThread != (i > 0) {
+			t.Errorf("request %d: unexpected %+v", i, req)
+		}
+		// the bot token is added when the request is sent
+		if req.Auth != deliveryAuthBearer || strings.Contains(req.Header["Authorization"]+req.Body, ch.Token) {
+			t.Errorf("request %d: expected the token not to be saved with the request: %+v", i, req)
+		}
+		message := &SlackMessage{}
+		if err := json.Unmarshal([]byte(req.Body), message); err != nil || message.Channel != "C123" {
+			t.Errorf("request %d: expected the message to the channel C123, got %s", i, req.Body)
+		}
+	}
+}
+
+func TestValidateSlackChannel(t *testing.T) {
+	tests := []struct {
+		format, recipient, token string
+		valid                    bool
+	}{
+		{"", "", "", true},
+		{slackDigest, "", "", true},
+		{slackThreaded, "C123", "xoxb-1", true},
+		{slackThreaded, "", "xoxb-1", false},
+		{slackThreaded, "C123", "xoxp-1", false},
+		{"unknown", "", "", false},
+	}
+	for _, tt := range tests {
+		err := validateSlackChannel(&NotificationChannel{Format: tt.format, Recipient: tt.recipient, Token: tt.token}, "slack")
+		if (err == nil) != tt.valid {
+			t.Errorf("%q %q %q: expected valid %v, got %v", tt.format, tt.recipient, tt.token, tt.valid, err)
+		}
+	}
+}
+
+func TestSlackDigestAndSummary(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com"}
+
+	var diffs gnDiffDatum
+	for i := 0; i < 200; i++ {
+		diffs = append(diffs, testRepoDiff(fmt.Sprintf("rails/repo-%d", i)))
+	}
+	tests := []struct {
+		name     string
+		diffs    gnDiffDatum
+		messages int
+		text     string
+	}{
+		{"digest", diffs[:3], 1, "Changes for 3 repositories"},
+		{"digest of many repos", diffs, 25, "Changes for 200 repositories"},
+		{"no changes", gnDiffDatum{{Repo: link{Text: "nodejs/node"}}}, 0, ""},
+	}
+	for _, tt := range tests {
+		messages := slackDigestMessages(tt.diffs, "1")
+		if len(messages) != tt.messages {
+			t.Errorf("%s: expected %d messages, got %d", tt.name, tt.messages, len(messages))
+		}
+		for _, message := range messages {
+			if message.Text != tt.text || len(message.Blocks) > slackMaxBlocks {
+				t.Errorf("%s: unexpected message %q of %d blocks", tt.name, message.Text, len(message.Blocks))
+			}
+		}
+	}
+
+	summary := slackSummaryMessage(diffs, "1")
+	if summary.Text != "Changes for 200 repositories" || len(summary.Blocks) > slackMaxBlocks {
+		t.Errorf("unexpected summary %q of %d blocks", summary.Text, len(summary.Blocks))
+	}
+	for _, block := range summary.Blocks {
+		if block.Type == "section" && len(block.Text.Text) > slackMaxSectionText {
+			t.Errorf("section of %d characters in the summary", len(block.Text.Text))
+		}
+	}
+	if last := summary.Blocks[len(summary.Blocks)-1]; last.Type != "actions" {
+		t.Errorf("expected the summary to link to the changes, got %s", last.Type)
+	}
+}
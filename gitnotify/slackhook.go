@@ -1,25 +1,53 @@
 package gitnotify
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"strings"
 )
 
+// Limits from https://api.slack.com/reference/block-kit/blocks
+const (
+	slackMaxBlocks      = 50
+	slackMaxHeaderText  = 150
+	slackMaxSectionText = 3000
+)
+
 // SlackMessage ..
 type SlackMessage struct {
+	Channel     string            `json:"channel,omitempty"`   // only for the Web API
+	ThreadTS    string            `json:"thread_ts,omitempty"` // only for the Web API
 	Username    string            `json:"username"`
-	Text        string            `json:"text"`
-	Attachments []SlackAttachment `json:"attachments"`
+	Text        string            `json:"text"` // displayed in the notifications when there are blocks
+	Blocks      []*SlackBlock     `json:"blocks,omitempty"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+// SlackBlock is one of the header, section, context or actions block of the Block Kit
+type SlackBlock struct {
+	Type     string           `json:"type"`
+	Text     *SlackTextObject `json:"text,omitempty"`
+	Elements []interface{}    `json:"elements,omitempty"` // text objects for context and buttons for actions
+}
+
+// SlackTextObject ..
+type SlackTextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackButton ..
+type SlackButton struct {
+	Type string           `json:"type"`
+	Text *SlackTextObject `json:"text"`
+	URL  string           `json:"url"`
 }
 
 // SlackAttachment ..
 type SlackAttachment struct {
 	Fallback       string                 `json:"fallback"`
 	Title          string                 `json:"title"`
+	TitleLink      string                 `json:"title_link,omitempty"`
 	Color          string                 `json:"color,omitempty"`
 	PreText        string                 `json:"pretext"`
 	AuthorName     string                 `json:"author_name"`
@@ -45,96 +73,249 @@ type SlackTypeLink struct {
 
 // <http://www.amazon.com|Amazon>
 func (s *SlackTypeLink) String() string {
-	return fmt.Sprintf("<%s|%s>", s.Href, s.Text)
+	return fmt.Sprintf("<%s|%s>", s.Href, slackEscape(s.Text))
+}
+
+// https://api.slack.com/reference/surfaces/formatting#escaping
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackEscape(text string) string {
+	return slackEscaper.Replace(text)
+}
+
+func slackPlainText(text string) *SlackTextObject {
+	return &SlackTextObject{Type: "plain_text", Text: text}
+}
+
+func slackSection(text string) *SlackBlock {
+	return &SlackBlock{Type: "section", Text: &SlackTextObject{Type: "mrkdwn", Text: text}}
+}
+
+func slackContext(text string) *SlackBlock {
+	return &SlackBlock{Type: "context", Elements: []interface{}{&SlackTextObject{Type: "mrkdwn", Text: text}}}
 }
 
-func processForWebhook(diff gnDiffDatum, conf *Setting) error {
-	if conf.User.isValidWebhook() {
-		if conf.User.WebhookType == "slack" {
-			log.Print("POSTing on a Slack Hook")
-			return processForSlack(diff, conf.User.WebhookURL)
+// slackChangesButton links to the changes page of gitnotify
+func slackChangesButton(fileName string) *SlackBlock {
+	return &SlackBlock{
+		Type: "actions",
+		Elements: []interface{}{&SlackButton{
+			Type: "button",
+			Text: slackPlainText("View all changes"),
+			URL:  fmt.Sprintf("%s/changes/%s", config.websiteURL(), fileName),
+		}},
+	}
+}
+
+// processForWebhook fans out the diff to all the enabled channels of the user
+func processForWebhook(diff gnDiffDatum, conf *Setting, fileName string) error {
+	var lastErr error
+	for _, ch := range conf.User.Channels {
+		if !ch.isValid() {
+			continue
+		}
+		channelDiff := conf.diffsForChannel(diff, ch)
+		if !channelDiff.hasChanges() {
+			continue
+		}
+		if err := processForChannel(channelDiff, conf, ch, fileName); err != nil {
+			log.Printf("Error notifying %s/%s on channel %s: %s", conf.Auth.Provider, conf.Auth.UserName, ch, err)
+			lastErr = err
 		}
-		// generic webhook
-		log.Print("POSTing on a Generic Webhook")
-		return processForGenericWebhook(diff, conf.User.WebhookURL)
 	}
-	return nil
+	return lastErr
 }
 
-func processForGenericWebhook(diffs gnDiffDatum, url string) error {
-	return postJSONtoURL(url, diffs)
+func processForChannel(diff gnDiffDatum, conf *Setting, ch *NotificationChannel, fileName string) error {
+	if ch.Type == emailChannelType {
+		return processForMailTo(diff, conf, fileName, ch)
+	}
+
+	deliveryID, err := newDeliveryID()
+	if err != nil {
+		return err
+	}
+	requests, err := channelRequests(diff, conf, ch, deliveryID, fileName)
+	if err != nil {
+		return err
+	}
+	log.Printf("Delivering %d request(s) on %s", len(requests), ch)
+	return deliver(conf, ch, deliveryID, fileName, requests)
+}
+
+// channelRequests renders the requests to be delivered on a webhook/chat channel
+func channelRequests(diff gnDiffDatum, conf *Setting, ch *NotificationChannel, deliveryID, fileName string) ([]*deliveryRequest, error) {
+	switch ch.Type {
+	case "slack":
+		switch ch.Format {
+		case slackThreaded:
+			return slackThreadRequests(diff, ch, fileName)
+		case slackDigest:
+			return slackRequests(slackDigestMessages(diff, fileName), ch.Target)
+		}
+		return slackRequests(slackMessages(diff, fileName), ch.Target)
+	case "teams":
+		return teamsRequests(diff, ch.Target)
+	case "discord":
+		return discordRequests(diff, ch.Target)
+	case matrixChannelType:
+		return matrixRequests(diff, conf, ch, fileName)
+	case telegramChannelType:
+		return telegramRequests(diff, ch)
+	case ntfyChannelType:
+		return ntfyRequests(diff, ch, fileName)
+	case gotifyChannelType:
+		return gotifyRequests(diff, ch, fileName)
+	case "mattermost":
+		return slackRequests(chatMessages(diff, mattermostFormat), ch.Target)
+	case "rocketchat":
+		return slackRequests(chatMessages(diff, rocketChatFormat), ch.Target)
+	}
+	return genericRequests(diff, ch, deliveryID, fileName)
 }
 
-func postJSONtoURL(url string, data interface{}) error {
-	pr, pw := io.Pipe()
-	go func() {
-		// close the writer, so the reader knows there's no more data
-		defer pw.Close()
+// slackRequests POSTs the messages on a slack compatible incoming webhook
+func slackRequests(messages []*SlackMessage, slackURL string) ([]*deliveryRequest, error) {
+	requests := make([]*deliveryRequest, 0, len(messages))
+	for _, message := range messages {
+		req, err := newJSONRequest("POST", slackURL, message)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
 
-		// write json data to the PipeReader through the PipeWriter
-		if err := json.NewEncoder(pw).Encode(data); err != nil {
-			log.Print(err)
+// slackMessages constructs the block kit messages for each changed repo
+func slackMessages(diffs []*gnDiffData, fileName string) []*SlackMessage {
+	var messages []*SlackMessage
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
 		}
-	}()
+		text := fmt.Sprintf("Changes for %s", repo.Repo.Text)
+		messages = append(messages, packSlackBlocks([][]*SlackBlock{slackRepoBlocks(repo)}, text)...)
+	}
+	return withSlackChangesButton(messages, fileName)
+}
 
-	if _, err := http.Post(url, "application/json", pr); err != nil {
-		return err
+// slackDigestMessages constructs block kit messages containing all the changed repos
+func slackDigestMessages(diffs []*gnDiffData, fileName string) []*SlackMessage {
+	var groups [][]*SlackBlock
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
+		}
+		groups = append(groups, slackRepoBlocks(repo))
 	}
-	return nil
+	text := fmt.Sprintf("Changes for %d repositories", len(groups))
+	return withSlackChangesButton(packSlackBlocks(groups, text), fileName)
 }
 
-func processForSlack(diffs []*gnDiffData, slackURL string) error {
-	// loop and construct the slack message and send it
+// slackSummaryMessage lists the changed repos. It is the parent of the threaded messages
+func slackSummaryMessage(diffs []*gnDiffData, fileName string) *SlackMessage {
+	var links []string
 	for _, repo := range diffs {
 		if repo.Changed == false {
 			continue
 		}
-		attachments := make([]SlackAttachment, 1)
-		for _, diff := range repo.Data {
+		links = append(links, (&SlackTypeLink{repo.Repo.Text, repo.Repo.Href}).String())
+	}
+	text := fmt.Sprintf("Changes for %d repositories", len(links))
+
+	blocks := []*SlackBlock{{Type: "header", Text: slackPlainText(text)}}
+	section := ""
+	for _, l := range links {
+		// the replies have the details, the summary is cut short when there are too many repos
+		if len(section)+len(l)+1 > slackMaxSectionText {
+			blocks = append(blocks, slackSection(section))
+			section = ""
+			if len(blocks) == slackMaxBlocks-1 {
+				break
+			}
+		}
+		section += l + "\n"
+	}
+	if section != "" && len(blocks) < slackMaxBlocks-1 {
+		blocks = append(blocks, slackSection(section))
+	}
+	message := &SlackMessage{Username: "gitnotify", Text: text, Blocks: blocks}
+	return withSlackChangesButton([]*SlackMessage{message}, fileName)[0]
+}
 
-			if diff.Changed == false {
-				continue
+// packSlackBlocks packs the groups of blocks, each starting with a header, in to messages
+// a group is continued in another message with its header when it exceeds the maximum blocks of a message
+func packSlackBlocks(groups [][]*SlackBlock, text string) []*SlackMessage {
+	var messages []*SlackMessage
+	var current *SlackMessage
+	for _, blocks := range groups {
+		header := blocks[0]
+		for first := true; len(blocks) > 0; first = false {
+			if !first {
+				blocks = append([]*SlackBlock{header}, blocks...)
+			}
+			// a block is left for the button to the changes page
+			if current == nil || (len(current.Blocks) > 0 && len(current.Blocks)+len(blocks) > slackMaxBlocks-1) {
+				current = &SlackMessage{Username: "gitnotify", Text: text}
+				messages = append(messages, current)
+			}
+			n := slackMaxBlocks - 1 - len(current.Blocks)
+			if n > len(blocks) {
+				n = len(blocks)
 			}
-			if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
-				if diff.Error == "" {
-					a := diff.Changes[0]
-					attachment := SlackAttachment{
-						Title:          (&SlackTypeLink{diff.Title.Text, diff.Title.Href}).String(),
-						Text:           (&SlackTypeLink{a.Text, a.Href}).String(),
-						MarkdownFormat: []string{"text"},
-					}
-					attachments = append(attachments, attachment)
-				} else {
-					attachment := SlackAttachment{
-						Title:          diff.Title.Text,
-						Text:           diff.Error,
-						MarkdownFormat: []string{},
-					}
-					attachments = append(attachments, attachment)
-				}
+			current.Blocks = append(current.Blocks, blocks[:n]...)
+			blocks = blocks[n:]
+		}
+	}
+	return messages
+}
 
+// withSlackChangesButton links the last message to the changes page, which has all of the diff
+func withSlackChangesButton(messages []*SlackMessage, fileName string) []*SlackMessage {
+	if len(messages) > 0 && fileName != "" {
+		last := messages[len(messages)-1]
+		last.Blocks = append(last.Blocks, slackChangesButton(fileName))
+	}
+	return messages
+}
+
+// slackRepoBlocks has a header for the repo, a section for each branch/tag change
+// followed by a context block with the commit range
+func slackRepoBlocks(repo *gnDiffData) []*SlackBlock {
+	blocks := []*SlackBlock{{
+		Type: "header",
+		Text: slackPlainText(truncateString("Changes for "+repo.Repo.Text, slackMaxHeaderText)),
+	}}
+
+	for _, diff := range repo.Data {
+		if diff.Changed == false {
+			continue
+		}
+		if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
+			title := fmt.Sprintf("*%s%s*", slackEscape(diff.Title.Title), &SlackTypeLink{diff.Title.Text, diff.Title.Href})
+			blocks = append(blocks, slackSection(title))
+			if diff.Error != "" {
+				blocks = append(blocks, slackContext(slackEscape(diff.Error)))
 			} else {
-				var links []string
-				for _, change := range diff.Changes {
-					links = append(links, (&SlackTypeLink{change.Text, change.Href}).String())
-				}
-
-				attachment := SlackAttachment{
-					Title:          fmt.Sprintf(diff.Title.Title),
-					Text:           strings.Join(links, "\n"),
-					MarkdownFormat: []string{"text"},
-				}
-				attachments = append(attachments, attachment)
+				a := diff.Changes[0]
+				blocks = append(blocks, slackContext(fmt.Sprintf("%s %s", slackEscape(a.Title), &SlackTypeLink{a.Text, a.Href})))
 			}
+			continue
 		}
 
-		message := &SlackMessage{
-			Username:    "gitnotify",
-			Text:        fmt.Sprintf("*Changes for %s*:", &SlackTypeLink{repo.Repo.Text, repo.Repo.Href}),
-			Attachments: attachments,
+		// new branches/tags are split over several sections when they do not fit in one
+		title := fmt.Sprintf("*%s*", slackEscape(diff.heading()))
+		text := title
+		for _, change := range diff.Changes {
+			l := (&SlackTypeLink{change.Text, change.Href}).String()
+			if len(text)+len(l)+1 > slackMaxSectionText {
+				blocks = append(blocks, slackSection(text))
+				text = title
+			}
+			text += "\n" + l
 		}
-
-		postJSONtoURL(slackURL, message)
+		blocks = append(blocks, slackSection(text))
 	}
-	return nil
+	return blocks
 }
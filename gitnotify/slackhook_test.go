@@ -0,0 +1,108 @@
+package gitnotify
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSlackTypeLink(t *testing.T) {
+	tests := []struct {
+		link *SlackTypeLink
+		want string
+	}{
+		{&SlackTypeLink{"rails/rails", "https://github.com/rails/rails"}, "<https://github.com/rails/rails|rails/rails>"},
+		{&SlackTypeLink{"a<b>&c", "https://github.com/a"}, "<https://github.com/a|a&lt;b&gt;&amp;c>"},
+	}
+	for _, tt := range tests {
+		if got := tt.link.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+// testBlocks is a group of a header followed by n sections
+func testBlocks(name string, n int) []*SlackBlock {
+	blocks := []*SlackBlock{{Type: "header", Text: slackPlainText(name)}}
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, slackSection(fmt.Sprintf("%s-%d", name, i)))
+	}
+	return blocks
+}
+
+func TestPackSlackBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups [][]*SlackBlock
+		blocks []int
+	}{
+		{"one group", [][]*SlackBlock{testBlocks("a", 3)}, []int{4}},
+		{"groups in a message", [][]*SlackBlock{testBlocks("a", 3), testBlocks("b", 3)}, []int{8}},
+		{"group in the next message", [][]*SlackBlock{testBlocks("a", 40), testBlocks("b", 10)}, []int{41, 11}},
+		{"group continued with its header", [][]*SlackBlock{testBlocks("a", 100)}, []int{49, 49, 5}},
+	}
+	for _, tt := range tests {
+		messages := packSlackBlocks(tt.groups, "text")
+		var got []int
+		for _, message := range messages {
+			got = append(got, len(message.Blocks))
+			if message.Blocks[0].Type != "header" {
+				t.Errorf("%s: expected every message to start with a header, got %s", tt.name, message.Blocks[0].Type)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.blocks) {
+			t.Errorf("%s: expected messages of %v blocks, got %v", tt.name, tt.blocks, got)
+		}
+	}
+}
+
+func TestSlackMessages(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com"}
+
+	tests := []struct {
+		name     string
+		diffs    gnDiffDatum
+		fileName string
+		messages int
+	}{
+		{"repo per message", gnDiffDatum{testRepoDiff("rails/rails"), testRepoDiff("golang/go"), {Repo: link{Text: "nodejs/node"}}}, "1", 2},
+		{"many tags", gnDiffDatum{testTagsDiff("rails/rails", 5000)}, "1", 3},
+		{"no changes", gnDiffDatum{{Repo: link{Text: "nodejs/node"}}}, "1", 0},
+	}
+	for _, tt := range tests {
+		messages := slackMessages(tt.diffs, tt.fileName)
+		if len(messages) != tt.messages {
+			t.Errorf("%s: expected %d messages, got %d", tt.name, tt.messages, len(messages))
+		}
+		for i, message := range messages {
+			if len(message.Blocks) > slackMaxBlocks {
+				t.Errorf("%s: message of %d blocks", tt.name, len(message.Blocks))
+			}
+			for _, block := range message.Blocks {
+				if block.Type == "section" && len(block.Text.Text) > slackMaxSectionText {
+					t.Errorf("%s: section of %d characters", tt.name, len(block.Text.Text))
+				}
+			}
+			last := message.Blocks[len(message.Blocks)-1]
+			if (last.Type == "actions") != (i == len(messages)-1) {
+				t.Errorf("%s: expected only the last message to link to the changes", tt.name)
+			}
+		}
+	}
+
+	blocks := slackRepoBlocks(testRepoDiff("rails/rails"))
+	want := []string{"header", "section", "context", "section", "context", "section"}
+	var got []string
+	for _, block := range blocks {
+		got = append(got, block.Type)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected the blocks %v, got %v", want, got)
+	}
+
+	org := slackRepoBlocks(testOrgDiff("kubernetes"))
+	if text := org[len(org)-1].Text.Text; text != "*kubernetes*\n<https://github.com/kubernetes/new-repo|new-repo>" {
+		t.Errorf("expected the new repos under the name of the org, got %q", text)
+	}
+}
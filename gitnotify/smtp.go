@@ -0,0 +1,273 @@
+package gitnotify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The smtp transport is configured with
+//
+//	smtpTLS                 none, starttls (default) or implicit TLS, usually on port 465
+//	smtpCAFile              PEM bundle of the CAs trusted for the server instead of the system CAs
+//	smtpInsecureSkipVerify  skips the verification of the certificate. Use only for testing
+//	smtpAuth                plain (default), login, cram-md5 or none for relays without authentication
+//	smtpPoolSize            connections kept open to be reused by the next emails. 0 opens a connection per email
+const (
+	smtpTLSNone     = "none"
+	smtpTLSStartTLS = "starttls"
+	smtpTLSImplicit = "implicit"
+
+	smtpAuthPlain   = "plain"
+	smtpAuthLogin   = "login"
+	smtpAuthCRAMMD5 = "cram-md5"
+	smtpAuthNone    = "none"
+
+	smtpDialTimeout = 30 * time.Second
+	// deadline of the commands sending an email, including the setup of new connections and the reset of pooled ones
+	smtpSendTimeout = 2 * time.Minute
+	// pooled connections idle for longer are closed since servers drop them
+	smtpIdleTimeout = time.Minute
+)
+
+func smtpTLSModes() []string {
+	return []string{smtpTLSNone, smtpTLSStartTLS, smtpTLSImplicit}
+}
+
+func smtpAuthMechanisms() []string {
+	return []string{smtpAuthPlain, smtpAuthLogin, smtpAuthCRAMMD5, smtpAuthNone}
+}
+
+// validateSMTP sets the defaults of the smtp transport and loads the CA bundle
+func (c *AppConfig) validateSMTP() error {
+	c.SMTPTLS = strings.ToLower(c.SMTPTLS)
+	c.SMTPAuth = strings.ToLower(c.SMTPAuth)
+	if c.SMTPTLS == "" {
+		c.SMTPTLS = smtpTLSStartTLS
+	}
+	if c.SMTPAuth == "" {
+		c.SMTPAuth = smtpAuthPlain
+	}
+	if !StringIn(smtpTLSModes(), c.SMTPTLS) {
+		return fmt.Errorf("smtpTLS should be one of %s", strings.Join(smtpTLSModes(), ", "))
+	}
+	if !StringIn(smtpAuthMechanisms(), c.SMTPAuth) {
+		return fmt.Errorf("smtpAuth should be one of %s", strings.Join(smtpAuthMechanisms(), ", "))
+	}
+	// same as net/smtp, the password is sent only over TLS or to localhost
+	if c.SMTPTLS == smtpTLSNone && (c.SMTPAuth == smtpAuthPlain || c.SMTPAuth == smtpAuthLogin) && !isLocalhost(c.SMTPHost) {
+		return fmt.Errorf("smtpAuth %s needs smtpTLS starttls or implicit, or use smtpAuth cram-md5 or none", c.SMTPAuth)
+	}
+	if c.SMTPPoolSize < 0 {
+		return errors.New("smtpPoolSize should not be negative")
+	}
+	if c.SMTPPort == 0 {
+		c.SMTPPort = 587
+		if c.SMTPTLS == smtpTLSImplicit {
+			c.SMTPPort = 465
+		}
+	}
+
+	c.smtpTLSConfig = &tls.Config{
+		ServerName:         c.SMTPHost,
+		InsecureSkipVerify: c.SMTPSkipVerify,
+	}
+	if c.SMTPCAFile != "" {
+		data, err := ioutil.ReadFile(c.SMTPCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("smtpCAFile %s has no PEM certificates", c.SMTPCAFile)
+		}
+		c.smtpTLSConfig.RootCAs = pool
+	}
+	return nil
+}
+
+// loginAuth implements the LOGIN mechanism which is not part of net/smtp
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// same as smtp.PlainAuth, credentials are not sent in clear text
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func smtpAuthentication() smtp.Auth {
+	switch config.SMTPAuth {
+	case smtpAuthLogin:
+		return &loginAuth{config.SMTPUser, config.SMTPPass}
+	case smtpAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(config.SMTPUser, config.SMTPPass)
+	case smtpAuthNone:
+		return nil
+	}
+	return smtp.PlainAuth("", config.SMTPUser, config.SMTPPass, config.SMTPHost)
+}
+
+type smtpConn struct {
+	client *smtp.Client
+	conn   net.Conn // for the deadlines, which smtp.Client does not set
+	usedAt time.Time
+}
+
+// smtpPool keeps up to config.SMTPPoolSize idle connections to send a batch of emails without reconnecting
+type smtpPool struct {
+	sync.Mutex
+	idle []*smtpConn
+}
+
+var smtpConnections = &smtpPool{}
+
+// dial connects, upgrades to TLS and authenticates as per the config
+func (p *smtpPool) dial() (*smtpConn, error) {
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+
+	var conn net.Conn
+	var err error
+	if config.SMTPTLS == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config.smtpTLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+
+	c, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = p.setup(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &smtpConn{client: c, conn: conn}, nil
+}
+
+func (p *smtpPool) setup(c *smtp.Client) error {
+	if host := config.serverHostWithoutPort(); host != "" {
+		if err := c.Hello(host); err != nil {
+			return err
+		}
+	}
+	if config.SMTPTLS == smtpTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", config.SMTPHost)
+		}
+		if err := c.StartTLS(config.smtpTLSConfig); err != nil {
+			return err
+		}
+	}
+	if auth := smtpAuthentication(); auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns an idle connection which is still usable or a new connection
+func (p *smtpPool) get() (*smtpConn, error) {
+	for {
+		p.Lock()
+		if len(p.idle) == 0 {
+			p.Unlock()
+			return p.dial()
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.Unlock()
+
+		if time.Since(conn.usedAt) < smtpIdleTimeout {
+			conn.conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+			if conn.client.Reset() == nil {
+				return conn, nil
+			}
+		}
+		conn.client.Close()
+	}
+}
+
+// put keeps the connection for the next email or closes it when the pool is full
+func (p *smtpPool) put(c *smtpConn) {
+	p.Lock()
+	if len(p.idle) < config.SMTPPoolSize {
+		c.usedAt = time.Now()
+		p.idle = append(p.idle, c)
+		p.Unlock()
+		return
+	}
+	p.Unlock()
+	c.client.Quit()
+}
+
+// send delivers the message to the recipients
+func (p *smtpPool) send(from string, to []string, message []byte) error {
+	c, err := p.get()
+	if err != nil {
+		return err
+	}
+	// get sets the deadline of the new or reused connection, which is renewed for the email itself
+	c.conn.SetDeadline(time.Now().Add(smtpSendTimeout))
+	if err = smtpSend(c.client, from, to, message); err != nil {
+		c.client.Close()
+		return err
+	}
+	p.put(c)
+	return nil
+}
+
+func smtpSend(c *smtp.Client, from string, to []string, message []byte) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
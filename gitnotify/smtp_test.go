@@ -0,0 +1,143 @@
+package gitnotify
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateSMTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		host, tls    string
+		auth         string
+		port         int
+		valid        bool
+		expectedTLS  string
+		expectedAuth string
+		expectedPort int
+	}{
+		{"defaults", "smtp.example.com", "", "", 0, true, smtpTLSStartTLS, smtpAuthPlain, 587},
+		{"implicit", "smtp.example.com", "IMPLICIT", "login", 0, true, smtpTLSImplicit, smtpAuthLogin, 465},
+		{"port", "smtp.example.com", "", "", 2525, true, smtpTLSStartTLS, smtpAuthPlain, 2525},
+		{"no tls with plain", "smtp.example.com", "none", "plain", 25, false, "", "", 0},
+		{"no tls with login", "smtp.example.com", "none", "login", 25, false, "", "", 0},
+		{"no tls with cram-md5", "smtp.example.com", "none", "cram-md5", 25, true, smtpTLSNone, smtpAuthCRAMMD5, 25},
+		{"no tls without auth", "smtp.example.com", "none", "none", 25, true, smtpTLSNone, smtpAuthNone, 25},
+		{"no tls to localhost", "localhost", "none", "plain", 25, true, smtpTLSNone, smtpAuthPlain, 25},
+		{"unknown tls", "smtp.example.com", "ssl", "", 0, false, "", "", 0},
+		{"unknown auth", "smtp.example.com", "", "xoauth2", 0, false, "", "", 0},
+	}
+	for _, tt := range tests {
+		c := &AppConfig{SMTPHost: tt.host, SMTPTLS: tt.tls, SMTPAuth: tt.auth, SMTPPort: tt.port}
+		err := c.validateSMTP()
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+			continue
+		}
+		if err == nil && (c.SMTPTLS != tt.expectedTLS || c.SMTPAuth != tt.expectedAuth || c.SMTPPort != tt.expectedPort) {
+			t.Errorf("%s: unexpected %s %s %d", tt.name, c.SMTPTLS, c.SMTPAuth, c.SMTPPort)
+		}
+	}
+
+	if err := (&AppConfig{SMTPHost: "smtp.example.com", SMTPPoolSize: -1}).validateSMTP(); err == nil {
+		t.Error("expected a negative pool size to be invalid")
+	}
+}
+
+// fakeSMTPServer accepts any email and counts the connections and the emails
+type fakeSMTPServer struct {
+	sync.Mutex
+	listener    net.Listener
+	connections int
+	messages    []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.connections++
+			s.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 go ahead")
+			var message []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				message = append(message, l)
+			}
+			s.Lock()
+			s.messages = append(s.messages, strings.Join(message, ""))
+			s.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPPoolReusesConnections(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{MailTransport: mailTransportSMTP, SMTPHost: host, SMTPTLS: smtpTLSNone, SMTPAuth: smtpAuthNone, SMTPPoolSize: 1}
+	config.SMTPPort, _ = net.LookupPort("tcp", port)
+	if err := config.validateMailTransport(); err != nil {
+		t.Fatal(err)
+	}
+	pool := &smtpPool{}
+
+	for i := 0; i < 3; i++ {
+		if err := pool.send("from@example.com", []string{"to@example.com"}, []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Lock()
+	connections, messages := server.connections, len(server.messages)
+	server.Unlock()
+	if connections != 1 || messages != 3 {
+		t.Fatalf("expected 3 emails over 1 connection, got %d emails over %d connections", messages, connections)
+	}
+	if len(pool.idle) != 1 || pool.idle[0].conn == nil {
+		t.Fatalf("expected the connection to be kept for the next email, got %d", len(pool.idle))
+	}
+}
@@ -0,0 +1,164 @@
+package gitnotify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Microsoft Teams incoming webhooks accept Adaptive Cards wrapped as a message attachment
+// https://learn.microsoft.com/en-us/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using
+
+const (
+	adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+	adaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+	adaptiveCardVersion     = "1.4"
+)
+
+// TeamsMessage ..
+type TeamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []TeamsAttachment `json:"attachments"`
+}
+
+// TeamsAttachment ..
+type TeamsAttachment struct {
+	ContentType string        `json:"contentType"`
+	Content     *AdaptiveCard `json:"content"`
+}
+
+// AdaptiveCard ..
+type AdaptiveCard struct {
+	Schema  string             `json:"$schema"`
+	Type    string             `json:"type"`
+	Version string             `json:"version"`
+	Body    []*AdaptiveElement `json:"body"`
+	MSTeams map[string]string  `json:"msteams,omitempty"`
+}
+
+// AdaptiveElement is one of TextBlock, Container, FactSet or ActionSet
+type AdaptiveElement struct {
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	Size      string             `json:"size,omitempty"`
+	Weight    string             `json:"weight,omitempty"`
+	Wrap      bool               `json:"wrap,omitempty"`
+	Separator bool               `json:"separator,omitempty"`
+	Items     []*AdaptiveElement `json:"items,omitempty"`
+	Facts     []AdaptiveFact     `json:"facts,omitempty"`
+	Actions   []AdaptiveAction   `json:"actions,omitempty"`
+}
+
+// AdaptiveFact ..
+type AdaptiveFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// AdaptiveAction ..
+type AdaptiveAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// [Amazon](http://www.amazon.com)
+func teamsLink(l link) string {
+	return fmt.Sprintf("[%s](%s)", l.Text, l.Href)
+}
+
+func teamsRequests(diffs gnDiffDatum, teamsURL string) ([]*deliveryRequest, error) {
+	req, err := newJSONRequest("POST", teamsURL, teamsMessage(diffs))
+	if err != nil {
+		return nil, err
+	}
+	return []*deliveryRequest{req}, nil
+}
+
+// teamsMessage constructs a single card with one section per changed repo
+func teamsMessage(diffs gnDiffDatum) *TeamsMessage {
+	card := &AdaptiveCard{
+		Schema:  adaptiveCardSchema,
+		Type:    "AdaptiveCard",
+		Version: adaptiveCardVersion,
+		Body: []*AdaptiveElement{{
+			Type:   "TextBlock",
+			Text:   "Changes from gitnotify",
+			Size:   "Large",
+			Weight: "Bolder",
+			Wrap:   true,
+		}},
+		MSTeams: map[string]string{"width": "Full"},
+	}
+
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
+		}
+		card.Body = append(card.Body, teamsRepoSection(repo))
+	}
+
+	return &TeamsMessage{
+		Type: "message",
+		Attachments: []TeamsAttachment{{
+			ContentType: adaptiveCardContentType,
+			Content:     card,
+		}},
+	}
+}
+
+// teamsRepoSection lists branch changes as facts and the compare links as buttons
+func teamsRepoSection(repo *gnDiffData) *AdaptiveElement {
+	section := &AdaptiveElement{
+		Type:      "Container",
+		Separator: true,
+		Items: []*AdaptiveElement{{
+			Type:   "TextBlock",
+			Text:   fmt.Sprintf("Changes for %s", teamsLink(repo.Repo)),
+			Size:   "Medium",
+			Weight: "Bolder",
+			Wrap:   true,
+		}},
+	}
+
+	var facts []AdaptiveFact
+	var actions []AdaptiveAction
+	var refs []*AdaptiveElement
+	for _, diff := range repo.Data {
+		if diff.Changed == false {
+			continue
+		}
+		if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
+			if diff.Error != "" {
+				facts = append(facts, AdaptiveFact{diff.Title.Text, diff.Error})
+				continue
+			}
+			change := diff.Changes[0]
+			facts = append(facts, AdaptiveFact{diff.Title.Text, change.Text})
+			actions = append(actions, AdaptiveAction{
+				Type:  "Action.OpenUrl",
+				Title: "Compare " + diff.Title.Text,
+				URL:   change.Href,
+			})
+			continue
+		}
+
+		var links []string
+		for _, change := range diff.Changes {
+			links = append(links, teamsLink(change))
+		}
+		refs = append(refs, &AdaptiveElement{
+			Type: "TextBlock",
+			Text: fmt.Sprintf("**%s** %s", diff.heading(), strings.Join(links, ", ")),
+			Wrap: true,
+		})
+	}
+
+	if len(facts) > 0 {
+		section.Items = append(section.Items, &AdaptiveElement{Type: "FactSet", Facts: facts})
+	}
+	section.Items = append(section.Items, refs...)
+	if len(actions) > 0 {
+		section.Items = append(section.Items, &AdaptiveElement{Type: "ActionSet", Actions: actions})
+	}
+	return section
+}
@@ -0,0 +1,77 @@
+package gitnotify
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testRepoDiff is a changed repo with a branch compare, a deleted branch and new tags
+func testRepoDiff(repo string) *gnDiffData {
+	return &gnDiffData{
+		Repo:    link{Text: repo, Href: "https://github.com/" + repo},
+		Changed: true,
+		Data: []diffData{
+			{Title: link{Text: "main"}, ChangeType: "repoBranchDiff", Changed: true, Changes: []link{{Text: "abc..def", Href: "https://github.com/" + repo + "/compare/abc...def"}}},
+			{Title: link{Text: "old"}, ChangeType: "repoBranchDiff", Changed: true, Error: "branch was deleted", Changes: []link{{Text: "old"}}},
+			{Title: link{Title: "New Tags "}, ChangeType: "repoTagDiff", Changed: true, Changes: []link{
+				{Text: "v1", Href: "https://github.com/" + repo + "/tree/v1"},
+				{Text: "v2", Href: "https://github.com/" + repo + "/tree/v2"},
+			}},
+			{Title: link{Text: "stale"}, ChangeType: "repoBranchDiff"},
+		},
+	}
+}
+
+// testOrgDiff is an org with a new repo, whose title is only the name of the org
+func testOrgDiff(org string) *gnDiffData {
+	return &gnDiffData{
+		Repo:    link{Text: org, Href: "https://github.com/" + org},
+		Changed: true,
+		Data: []diffData{
+			{Title: link{Text: org, Href: "https://github.com/" + org}, ChangeType: "orgRepoDiff", Changed: true, Changes: []link{
+				{Text: "new-repo", Href: "https://github.com/" + org + "/new-repo", Title: "A new repo"},
+			}},
+		},
+	}
+}
+
+func TestTeamsMessage(t *testing.T) {
+	diffs := gnDiffDatum{testRepoDiff("rails/rails"), {Repo: link{Text: "golang/go"}}}
+	msg := teamsMessage(diffs)
+	if msg.Type != "message" || len(msg.Attachments) != 1 || msg.Attachments[0].ContentType != adaptiveCardContentType {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	card := msg.Attachments[0].Content
+	if card.Version != adaptiveCardVersion || len(card.Body) != 2 {
+		t.Fatalf("expected a title and a section of the changed repo, got %d elements", len(card.Body))
+	}
+
+	section := card.Body[1]
+	types := make([]string, len(section.Items))
+	for i, item := range section.Items {
+		types[i] = item.Type
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"items", types, []string{"TextBlock", "FactSet", "TextBlock", "ActionSet"}},
+		{"title", section.Items[0].Text, "Changes for [rails/rails](https://github.com/rails/rails)"},
+		{"facts", section.Items[1].Facts, []AdaptiveFact{{"main", "abc..def"}, {"old", "branch was deleted"}}},
+		{"refs", section.Items[2].Text, "**New Tags** [v1](https://github.com/rails/rails/tree/v1), [v2](https://github.com/rails/rails/tree/v2)"},
+		{"actions", section.Items[3].Actions, []AdaptiveAction{{"Action.OpenUrl", "Compare main", "https://github.com/rails/rails/compare/abc...def"}}},
+	}
+	for _, tt := range tests {
+		got, _ := json.Marshal(tt.got)
+		want, _ := json.Marshal(tt.want)
+		if string(got) != string(want) {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, got)
+		}
+	}
+
+	org := teamsMessage(gnDiffDatum{testOrgDiff("kubernetes")}).Attachments[0].Content.Body[1].Items
+	if want := "**kubernetes** [new-repo](https://github.com/kubernetes/new-repo)"; org[1].Text != want {
+		t.Errorf("expected the org to be the title of its new repos %q, got %q", want, org[1].Text)
+	}
+}
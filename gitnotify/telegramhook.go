@@ -0,0 +1,174 @@
+package gitnotify
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Telegram notifications are sent by a bot using sendMessage of the Bot API
+// https://core.telegram.org/bots/api#sendmessage
+
+// Target is the Bot API server, Recipient the chat ID and Token the bot token of the channel
+const telegramChannelType = "telegram"
+
+const (
+	telegramAPIURL           = "https://api.telegram.org"
+	telegramMaxMessageLength = 4096
+)
+
+// Format of the telegram channel is the parse mode
+const (
+	telegramHTML       = "HTML"
+	telegramMarkdownV2 = "MarkdownV2"
+)
+
+// chat IDs are numeric, or the @username of a public channel
+var telegramChatIDRegex = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z0-9_]{5,})$`)
+
+// https://core.telegram.org/bots/api#markdownv2-style
+var telegramMarkdownEscaper = strings.NewReplacer(
+	`\`, `\\`, `_`, `\_`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`, `~`, `\~`, "`", "\\`",
+	`>`, `\>`, `#`, `\#`, `+`, `\+`, `-`, `\-`, `=`, `\=`, `|`, `\|`, `{`, `\{`, `}`, `\}`, `.`, `\.`, `!`, `\!`,
+)
+
+// inside the (...) part of a link only ) and \ are escaped
+var telegramMarkdownURLEscaper = strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+
+// TelegramMessage ..
+type TelegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// telegramFormatter escapes the text for a parse mode
+type telegramFormatter struct {
+	Text func(text string) string
+	Bold func(text string) string
+	Link func(text, href string) string
+}
+
+var telegramFormatters = map[string]*telegramFormatter{
+	telegramHTML: {
+		Text: html.EscapeString,
+		Bold: func(text string) string { return "<b>" + text + "</b>" },
+		Link: func(text, href string) string {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), html.EscapeString(text))
+		},
+	},
+	telegramMarkdownV2: {
+		Text: telegramMarkdownEscaper.Replace,
+		Bold: func(text string) string { return "*" + text + "*" },
+		Link: func(text, href string) string {
+			return fmt.Sprintf("[%s](%s)", telegramMarkdownEscaper.Replace(text), telegramMarkdownURLEscaper.Replace(href))
+		},
+	},
+}
+
+func validateTelegramChannel(ch *NotificationChannel, label string) error {
+	if !telegramChatIDRegex.MatchString(ch.Recipient) {
+		return fmt.Errorf("Channel %s: chat ID should be a number or the @username of a channel", label)
+	}
+	if ch.Token == "" {
+		return fmt.Errorf("Channel %s: bot token is required", label)
+	}
+	if ch.Format == "" {
+		ch.Format = telegramHTML
+	}
+	if telegramFormatters[ch.Format] == nil {
+		return fmt.Errorf("Channel %s: format should be one of %s", label, strings.Join(ChannelFormats()[telegramChannelType], ", "))
+	}
+	return nil
+}
+
+func telegramRequests(diffs gnDiffDatum, ch *NotificationChannel) ([]*deliveryRequest, error) {
+	parseMode := ch.Format
+	if telegramFormatters[parseMode] == nil {
+		parseMode = telegramHTML
+	}
+
+	var requests []*deliveryRequest
+	for _, text := range telegramMessages(diffs, telegramFormatters[parseMode]) {
+		message := &TelegramMessage{
+			ChatID:                ch.Recipient,
+			Text:                  text,
+			ParseMode:             parseMode,
+			DisableWebPagePreview: true,
+		}
+		req, err := newJSONRequest("POST", telegramSendURL(ch), message)
+		if err != nil {
+			return nil, err
+		}
+		req.ExpectOK = true
+		req.Auth = deliveryAuthURLToken
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// telegramMessages renders the changes line by line and splits them in to messages at line boundaries
+// so that the markup of a line is never broken
+func telegramMessages(diffs gnDiffDatum, f *telegramFormatter) []string {
+	var lines []string
+	for _, repo := range diffs {
+		if repo.Changed == false {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, f.Bold(f.Text("Changes for ")+f.Link(repo.Repo.Text, repo.Repo.Href)))
+
+		for _, diff := range repo.Data {
+			if diff.Changed == false {
+				continue
+			}
+			if diff.ChangeType == "repoBranchDiff" && len(diff.Changes) > 0 {
+				title := f.Bold(f.Text(diff.Title.Title + diff.Title.Text))
+				if diff.Error != "" {
+					lines = append(lines, title+" "+f.Text(diff.Error))
+				} else {
+					a := diff.Changes[0]
+					lines = append(lines, title+" "+f.Link(a.Text, a.Href))
+				}
+				continue
+			}
+
+			lines = append(lines, f.Bold(f.Text(diff.heading())))
+			for _, change := range diff.Changes {
+				lines = append(lines, f.Text("• ")+f.Link(change.Text, change.Href))
+			}
+		}
+	}
+
+	var messages []string
+	var current string
+	for _, line := range lines {
+		if utf8.RuneCountInString(line) > telegramMaxMessageLength {
+			log.Printf("Skipping a telegram line longer than %d characters", telegramMaxMessageLength)
+			continue
+		}
+		if current != "" && utf8.RuneCountInString(current)+1+utf8.RuneCountInString(line) > telegramMaxMessageLength {
+			messages = append(messages, current)
+			current = ""
+		}
+		if current != "" {
+			current += "\n"
+		}
+		current += line
+	}
+	if strings.TrimSpace(current) != "" {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// telegramSendURL has a placeholder for the bot token, which is added when the request is sent
+func telegramSendURL(ch *NotificationChannel) string {
+	return fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(ch.Target, "/"), deliveryTokenPlaceholder)
+}
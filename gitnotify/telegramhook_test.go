@@ -0,0 +1,104 @@
+package gitnotify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// the bot token is read from the channel when the message is sent, and is not saved with the delivery
+func TestTelegramTokenAddedWhenSent(t *testing.T) {
+	defer withDataDir(t)()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer server.Close()
+
+	conf, _, _ := testDelivery(server.URL)
+	ch := &NotificationChannel{Name: "bot", Type: telegramChannelType, Target: server.URL, Recipient: "-100123", Token: "123456:ABC-secret", Enabled: true}
+	saveTestSettings(t, conf, ch)
+
+	reqs, err := channelRequests(sampleDiff(), conf, ch, "1-t", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(reqs[0].URL, ch.Token) {
+		t.Fatalf("expected the url not to have the token when rendered: %s", reqs[0].URL)
+	}
+	if err = deliver(conf, ch, "1-t", "diff", reqs); err != nil {
+		t.Fatal(err)
+	}
+	d, err := findDelivery(conf, "1-t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := readCompressedFile(d.fileName(deliveryDelivered))
+	if strings.Contains(string(saved), ch.Token) {
+		t.Fatalf("expected the token not to be saved: %s", saved)
+	}
+	if len(paths) != 1 || paths[0] != "/bot123456:ABC-secret/sendMessage" {
+		t.Fatalf("expected the token in the path, got %q", paths)
+	}
+}
+
+func TestTelegramFormatters(t *testing.T) {
+	tests := []struct {
+		mode       string
+		text, href string
+		link       string
+		escaped    string
+	}{
+		{telegramHTML, "a<b>&c", "https://example.com/?a=1&b=2", `<a href="https://example.com/?a=1&amp;b=2">a&lt;b&gt;&amp;c</a>`, "a&lt;b&gt;&amp;c"},
+		{telegramMarkdownV2, "v1.0_rc-1", "https://example.com/a_(b)", `[v1\.0\_rc\-1](https://example.com/a_(b\))`, `v1\.0\_rc\-1`},
+		{telegramMarkdownV2, `a\b*[c]!`, `https://example.com/\`, `[a\\b\*\[c\]\!](https://example.com/\\)`, `a\\b\*\[c\]\!`},
+	}
+	for _, tt := range tests {
+		f := telegramFormatters[tt.mode]
+		if got := f.Link(tt.text, tt.href); got != tt.link {
+			t.Errorf("%s link %q: expected %s, got %s", tt.mode, tt.text, tt.link, got)
+		}
+		if got := f.Text(tt.text); got != tt.escaped {
+			t.Errorf("%s text %q: expected %s, got %s", tt.mode, tt.text, tt.escaped, got)
+		}
+	}
+}
+
+func TestTelegramMessages(t *testing.T) {
+	many := make([]link, 500)
+	for i := range many {
+		many[i] = link{Text: strings.Repeat("x", 20), Href: "https://github.com/rails/rails/tree/" + strings.Repeat("y", 20)}
+	}
+	diffs := gnDiffDatum{
+		&gnDiffData{Repo: link{Text: "a/b", Href: "https://github.com/a/b"}, Changed: true, Data: []diffData{
+			{Title: link{Title: "New Tags: "}, ChangeType: "repoRefDiff", Changed: true, Changes: many},
+		}},
+		&gnDiffData{Repo: link{Text: "c/d"}, Changed: false},
+	}
+	for mode, f := range telegramFormatters {
+		messages := telegramMessages(diffs, f)
+		if len(messages) < 2 {
+			t.Errorf("%s: expected the changes to be split, got %d messages", mode, len(messages))
+		}
+		lines := 0
+		for _, m := range messages {
+			if utf8.RuneCountInString(m) > telegramMaxMessageLength {
+				t.Errorf("%s: message of %d characters is too long", mode, utf8.RuneCountInString(m))
+			}
+			if strings.Contains(m, "c/d") {
+				t.Errorf("%s: unchanged repo was included", mode)
+			}
+			lines += strings.Count(m, "\n") + 1
+		}
+		// the title of the repo, the title of the tags and a line per tag
+		if lines != 2+len(many) {
+			t.Errorf("%s: expected %d lines, got %d", mode, 2+len(many), lines)
+		}
+	}
+	if messages := telegramMessages(gnDiffDatum{}, telegramFormatters[telegramHTML]); len(messages) != 0 {
+		t.Errorf("expected no messages without changes, got %q", messages)
+	}
+}
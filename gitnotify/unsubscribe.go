@@ -0,0 +1,258 @@
+package gitnotify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sairam/kinli"
+)
+
+// Emails have a List-Unsubscribe header with a signed url and a mailto address
+//
+//	List-Unsubscribe: <https://gitnotify.com/unsubscribe/TOKEN>, <mailto:unsub@gitnotify.com?subject=unsubscribe%20TOKEN>
+//	List-Unsubscribe-Post: List-Unsubscribe=One-Click
+//
+// TOKEN is <base64 of provider/username, the repo, the channel, the address and the issue time>.<base64 of the HMAC-SHA256 using config.UnsubscribeSecret>
+// Without a repo, the notifications of the user are disabled. With a repo (or org), only the repo is removed
+// Emails to the address of an email channel have a token of the channel, which only turns off that channel
+// since the recipient need not be the user. Tokens expire after unsubscribeTokenTTL like the emails they are in,
+// so a leaked link does not work forever. The notifications can always be changed at /user
+// Mails sent to the mailto address are processed by "gitnotify unsubscribe-mail" from a maildir or stdin
+const (
+	unsubscribeMailbox  = "unsub"
+	unsubscribeOneClick = "One-Click"
+	unsubscribeTokenTTL = 90 * 24 * time.Hour
+	// length of the signature in bytes before encoding
+	unsubscribeSignatureSize = 16
+)
+
+var unsubscribeSubjectRegex = regexp.MustCompile(`(?i)unsubscribe\s+([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`)
+
+// unsubscribeRequest is what a token unsubscribes from
+type unsubscribeRequest struct {
+	Token    string
+	Provider string
+	UserName string
+	Repo     string
+	Channel  string // email channel sending to Address, which is turned off instead of the notifications of the user
+	Address  string
+	IssuedAt time.Time
+}
+
+type invalidUnsubscribeToken struct{}
+
+func (invalidUnsubscribeToken) Error() string {
+	return "Unsubscribe link is invalid or has expired. Notifications can be changed at /user"
+}
+
+func unsubscribeSignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.UnsubscribeSecret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:unsubscribeSignatureSize])
+}
+
+// unsubscribeToken signs the user and the repo, or the email channel. It is empty when the secret is not configured
+func unsubscribeToken(conf *Setting, ch *NotificationChannel, repo string, issuedAt time.Time) string {
+	if config.UnsubscribeSecret == "" {
+		return ""
+	}
+	channel, address := "", ""
+	if ch.Name != primaryEmailChannel {
+		channel, address, repo = ch.Name, ch.Target, ""
+	}
+	payload := strings.Join([]string{conf.Auth.UserInfo(), repo, channel, address, strconv.FormatInt(issuedAt.Unix(), 10)}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + unsubscribeSignature(payload)
+}
+
+func parseUnsubscribeToken(token string) (*unsubscribeRequest, error) {
+	parts := strings.Split(token, ".")
+	if config.UnsubscribeSecret == "" || len(parts) != 2 {
+		return nil, &invalidUnsubscribeToken{}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, &invalidUnsubscribeToken{}
+	}
+	payload := string(data)
+	if !hmac.Equal([]byte(unsubscribeSignature(payload)), []byte(parts[1])) {
+		return nil, &invalidUnsubscribeToken{}
+	}
+
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 5 {
+		return nil, &invalidUnsubscribeToken{}
+	}
+	providerUser := strings.SplitN(fields[0], "/", 2)
+	issuedAt, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil || time.Since(time.Unix(issuedAt, 0)) > unsubscribeTokenTTL || len(providerUser) != 2 || !isSafePathName(providerUser[1]) {
+		return nil, &invalidUnsubscribeToken{}
+	}
+	return &unsubscribeRequest{
+		Token:    token,
+		Provider: providerUser[0],
+		UserName: providerUser[1],
+		Repo:     fields[1],
+		Channel:  fields[2],
+		Address:  fields[3],
+		IssuedAt: time.Unix(issuedAt, 0),
+	}, nil
+}
+
+// unsubscribeHeaders are added to the emails of the channel, for the repo when the email has a single repo
+func unsubscribeHeaders(conf *Setting, ch *NotificationChannel, repo string) map[string]string {
+	token := unsubscribeToken(conf, ch, repo, time.Now())
+	if token == "" {
+		return map[string]string{"List-Unsubscribe": fmt.Sprintf("<%s/user>", config.websiteURL())}
+	}
+	mailto := fmt.Sprintf("mailto:%s@%s?subject=%s", unsubscribeMailbox, config.serverHostWithoutPort(), url.PathEscape("unsubscribe "+token))
+	return map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s/unsubscribe/%s>, <%s>", config.websiteURL(), token, mailto),
+		"List-Unsubscribe-Post": "List-Unsubscribe=" + unsubscribeOneClick,
+	}
+}
+
+// Description is used by the view to display what will be unsubscribed
+func (u *unsubscribeRequest) Description() string {
+	if u.Channel != "" {
+		return fmt.Sprintf("the updates sent to %s by %s/%s", u.Address, u.Provider, u.UserName)
+	}
+	if u.Repo == "" {
+		return "all the notifications"
+	}
+	return "the updates of " + u.Repo
+}
+
+// apply disables the notifications of the user or removes the repo
+func (u *unsubscribeRequest) apply() error {
+	auth := &Authentication{Provider: u.Provider, UserName: u.UserName}
+	configFile := auth.getConfigFile()
+	defer lockSetting(configFile)()
+	conf := new(Setting)
+	if err := conf.load(configFile); err != nil || conf.Auth == nil {
+		return &invalidUnsubscribeToken{}
+	}
+
+	removed := true
+	if u.Channel != "" {
+		removed = u.disableChannel(conf)
+	} else if u.Repo == "" {
+		conf.User.Disabled = true
+	} else if strings.Contains(u.Repo, "/") {
+		removed, _ = deleteRepo(conf, &Repo{Repo: u.Repo})
+	} else {
+		removed, _ = deleteOrg(conf, &Organisation{Name: u.Repo})
+	}
+	if !removed {
+		// repo was removed earlier
+		return nil
+	}
+	if err := conf.save(configFile); err != nil {
+		return err
+	}
+	if crons != nil {
+		upsertCronEntry(conf)
+	}
+	log.Printf("Unsubscribed %s/%s from %s", u.Provider, u.UserName, u.Description())
+	return nil
+}
+
+// disableChannel turns off the email channel when it still sends to the address of the token
+func (u *unsubscribeRequest) disableChannel(conf *Setting) bool {
+	for _, ch := range conf.User.Channels {
+		if ch.Name == u.Channel && ch.Type == emailChannelType && strings.EqualFold(ch.Target, u.Address) && ch.Enabled {
+			ch.Enabled = false
+			return true
+		}
+	}
+	return false
+}
+
+// unsubscribeShowHandler asks to confirm since links in emails are opened by scanners
+func unsubscribeShowHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	u, err := parseUnsubscribeToken(mux.Vars(r)["token"])
+	if err != nil {
+		page := kinli.NewPage(hc, "Unsubscribe", getUserInfo(hc), err.Error(), nil)
+		kinli.DisplayPage(w, "text", page)
+		return
+	}
+
+	context := struct {
+		Request *unsubscribeRequest
+		Done    bool
+	}{u, false}
+	page := kinli.NewPage(hc, "Unsubscribe", getUserInfo(hc), context, nil)
+	kinli.DisplayPage(w, "unsubscribe", page)
+}
+
+// unsubscribeHandler handles the confirmation and the one-click POST of RFC 8058
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	hc := &kinli.HttpContext{W: w, R: r}
+	r.ParseForm()
+	oneClick := r.PostForm.Get("List-Unsubscribe") == unsubscribeOneClick
+
+	u, err := parseUnsubscribeToken(mux.Vars(r)["token"])
+	if err == nil {
+		err = u.apply()
+	}
+
+	if oneClick {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "Unsubscribed from %s\n", u.Description())
+		return
+	}
+
+	if err != nil {
+		page := kinli.NewPage(hc, "Unsubscribe", getUserInfo(hc), err.Error(), nil)
+		kinli.DisplayPage(w, "text", page)
+		return
+	}
+	context := struct {
+		Request *unsubscribeRequest
+		Done    bool
+	}{u, true}
+	page := kinli.NewPage(hc, "Unsubscribe", getUserInfo(hc), context, nil)
+	kinli.DisplayPage(w, "unsubscribe", page)
+}
+
+// processUnsubscribeMail reads the token from the subject of a mail sent to the mailto address
+func processUnsubscribeMail(r io.Reader) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	m := unsubscribeSubjectRegex.FindStringSubmatch(subject)
+	if m == nil {
+		return fmt.Errorf("no unsubscribe token in the subject %q", subject)
+	}
+	u, err := parseUnsubscribeToken(m[1])
+	if err != nil {
+		return err
+	}
+	return u.apply()
+}
+
+// processUnsubscribeMaildir processes the new mails of the maildir and moves them to cur
+func processUnsubscribeMaildir(dir string, w io.Writer) error {
+	return processMaildir(dir, w, processUnsubscribeMail, "unsubscribed")
+}
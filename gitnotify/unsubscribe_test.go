@@ -0,0 +1,123 @@
+package gitnotify
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{UnsubscribeSecret: "unsubscribe-secret"}
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{Email: "alice@example.com"}}
+	team := &NotificationChannel{Name: "team", Type: emailChannelType, Target: "team@example.com", Enabled: true}
+	now := time.Now()
+	legacyPayload := "github/alice\nrails/rails"
+	legacy := base64.RawURLEncoding.EncodeToString([]byte(legacyPayload)) + "." + unsubscribeSignature(legacyPayload)
+	valid := unsubscribeToken(conf, conf.primaryEmail(), "", now)
+
+	tests := []struct {
+		name    string
+		token   string
+		valid   bool
+		repo    string
+		channel string
+		address string
+	}{
+		{"all notifications", valid, true, "", "", ""},
+		{"repo", unsubscribeToken(conf, conf.primaryEmail(), "rails/rails", now), true, "rails/rails", "", ""},
+		{"channel", unsubscribeToken(conf, team, "rails/rails", now), true, "", "team", "team@example.com"},
+		{"nearly expired", unsubscribeToken(conf, conf.primaryEmail(), "", now.Add(-unsubscribeTokenTTL+time.Hour)), true, "", "", ""},
+		{"expired", unsubscribeToken(conf, conf.primaryEmail(), "", now.Add(-unsubscribeTokenTTL-time.Hour)), false, "", "", ""},
+		{"without an issue time", legacy, false, "", "", ""},
+		{"tampered", strings.Replace(valid, ".", "x.", 1), false, "", "", ""},
+		{"no signature", strings.Split(valid, ".")[0], false, "", "", ""},
+	}
+	for _, tt := range tests {
+		u, err := parseUnsubscribeToken(tt.token)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.Provider != "github" || u.UserName != "alice" || u.Repo != tt.repo || u.Channel != tt.channel || u.Address != tt.address {
+			t.Errorf("%s: unexpected request %+v", tt.name, u)
+		}
+	}
+
+	config.UnsubscribeSecret = "rotated"
+	if _, err := parseUnsubscribeToken(valid); err == nil {
+		t.Error("expected the token to be invalid with another secret")
+	}
+	config.UnsubscribeSecret = ""
+	if token := unsubscribeToken(conf, conf.primaryEmail(), "", now); token != "" {
+		t.Errorf("expected no token without a secret, got %s", token)
+	}
+}
+
+func TestUnsubscribeHeaders(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{UnsubscribeSecret: "unsubscribe-secret", ServerProto: "https", ServerHost: "gitnotify.example.com"}
+
+	conf := &Setting{Auth: &Authentication{Provider: "github", UserName: "alice"}, User: &UserNotification{}}
+	headers := unsubscribeHeaders(conf, &NotificationChannel{Name: "team", Type: emailChannelType, Target: "team@example.com"}, "")
+	if headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("unexpected List-Unsubscribe-Post %q", headers["List-Unsubscribe-Post"])
+	}
+	m := unsubscribeSubjectRegex.FindStringSubmatch(strings.Replace(headers["List-Unsubscribe"], url.PathEscape(" "), " ", -1))
+	if m == nil || !strings.HasPrefix(headers["List-Unsubscribe"], "<https://gitnotify.example.com/unsubscribe/"+m[1]+">") {
+		t.Fatalf("expected the same token in the url and the mailto, got %q", headers["List-Unsubscribe"])
+	}
+	u, err := parseUnsubscribeToken(m[1])
+	if err != nil || u.Channel != "team" {
+		t.Errorf("expected a token of the channel, got %+v %v", u, err)
+	}
+
+	config.UnsubscribeSecret = ""
+	if headers = unsubscribeHeaders(conf, conf.primaryEmail(), ""); headers["List-Unsubscribe"] != "<https://gitnotify.example.com/user>" {
+		t.Errorf("expected the settings url without a secret, got %v", headers)
+	}
+}
+
+// the token of an email channel turns off only the channel, not the notifications of the owner
+func TestUnsubscribeChannel(t *testing.T) {
+	defer withDataDir(t)()
+	config.UnsubscribeSecret = "unsubscribe-secret"
+	conf, _, _ := testDelivery("")
+	team := &NotificationChannel{Name: "team", Type: emailChannelType, Target: "team@example.com", Enabled: true}
+	other := &NotificationChannel{Name: "other", Type: emailChannelType, Target: "other@example.com", Enabled: true}
+	saveTestSettings(t, conf, team, other)
+
+	u, err := parseUnsubscribeToken(unsubscribeToken(conf, team, "rails/rails", time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.apply(); err != nil {
+		t.Fatal(err)
+	}
+	saved := new(Setting)
+	saved.load(conf.Auth.getConfigFile())
+	if saved.User.Disabled || saved.User.Channels[0].Enabled || !saved.User.Channels[1].Enabled {
+		t.Fatalf("expected only the channel to be turned off, got disabled %v and channels %v %v",
+			saved.User.Disabled, saved.User.Channels[0].Enabled, saved.User.Channels[1].Enabled)
+	}
+
+	// a token of an address the channel no longer sends to does nothing
+	team.Enabled, team.Target = true, "new-team@example.com"
+	saveTestSettings(t, conf, team, other)
+	if err = u.apply(); err != nil {
+		t.Fatal(err)
+	}
+	saved = new(Setting)
+	saved.load(conf.Auth.getConfigFile())
+	if !saved.User.Channels[0].Enabled {
+		t.Error("expected the channel sending to another address to stay on")
+	}
+}
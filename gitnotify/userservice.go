@@ -2,6 +2,7 @@ package gitnotify
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"net/mail"
@@ -39,6 +40,12 @@ func userSettingsShowHandler(w http.ResponseWriter, r *http.Request) {
 		conf.User.Name = conf.usersName()
 	}
 
+	for _, ch := range conf.User.Channels {
+		if ch.Type == genericChannelType && ch.Template != "" {
+			ch.preview = webhookPreview(conf, ch)
+		}
+	}
+
 	// Display next cron entries if cron is valid
 	pagedata := struct {
 		NextRunTimes []string
@@ -63,6 +70,7 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 	userInfo := getUserInfo(hc)
 	configFile := userInfo.getConfigFile()
 
+	defer lockSetting(configFile)()
 	conf := new(Setting)
 	conf.load(configFile)
 
@@ -77,11 +85,23 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 
 		r.ParseForm()
 
+		// addresses the confirmation link was emailed to
+		var confirmations []string
+
 		// validate
 		if len(r.Form["email"]) > 0 {
 			e, err := mail.ParseAddress(r.Form["email"][0])
 			if err == nil {
-				conf.User.Email = e.Address
+				sent, err := conf.changeEmail(e.Address)
+				if err != nil {
+					// nothing is saved so that the address is not pending without a link
+					hc.AddFlash(html.EscapeString(err.Error()))
+					http.Redirect(w, r, "/user", 302)
+					return
+				}
+				if sent {
+					confirmations = append(confirmations, e.Address)
+				}
 			} else {
 				hc.AddFlash("email address provided is invalid format")
 			}
@@ -95,6 +115,10 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if len(r.Form["emailGrouping"]) > 0 {
+			conf.User.PerRepoEmails = r.Form["emailGrouping"][0] == "repo"
+		}
+
 		if len(r.Form["disabled"]) > 0 {
 			if r.Form["disabled"][0] == "tRu3" {
 				conf.User.Disabled = true
@@ -114,14 +138,7 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 				hc.AddFlash(err.Error())
 			}
 		} else {
-			offset := convertTzOffsetToInt(conf.User.TimeZone)
-			timeZoneNames := tzByOffset[offset]
-			if len(timeZoneNames) > 0 {
-				conf.User.TimeZoneName = timeZoneNames[0].Location
-			} else {
-				log.Println("Could not find TimeZone Name for ", conf.User.TimeZone)
-				conf.User.TimeZoneName = "UTC"
-			}
+			conf.User.TimeZoneName = tzNameForOffset(conf.User.TimeZone)
 		}
 
 		conf.User.Hour = cleanHour(r.Form["hour"])
@@ -129,14 +146,23 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 		// validate weekday
 		conf.User.WeekDay = cleanWeekday(r.Form["weekday"])
 
-		if len(r.Form["webhookType"]) > 0 {
-			conf.User.WebhookType = r.Form["webhookType"][0]
+		if len(r.Form["channelTarget"]) > 0 {
+			channels, errs := parseChannelsForm(r.Form, conf.User)
+			for _, err := range errs {
+				hc.AddFlash(html.EscapeString(err.Error()))
+			}
+			sent, err := conf.setChannels(channels)
+			if err != nil {
+				hc.AddFlash(html.EscapeString(err.Error()))
+				http.Redirect(w, r, "/user", 302)
+				return
+			}
+			confirmations = append(confirmations, sent...)
 		}
+		conf.pruneInvalidEmails()
 
-		if len(r.Form["webhookURL"]) > 0 {
-			if _, err := url.ParseRequestURI(r.Form["webhookURL"][0]); err == nil {
-				conf.User.WebhookURL = r.Form["webhookURL"][0]
-			}
+		if len(confirmations) > 0 {
+			hc.AddFlash(html.EscapeString(fmt.Sprintf("Confirmation link was sent to %s. Updates are sent to an address once it is confirmed", strings.Join(confirmations, ", "))))
 		}
 
 		conf.save(configFile)
@@ -147,6 +173,62 @@ func userSettingsSaveHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// parseChannelsForm reads the channels from the list of channelName, channelType, channelTarget,
+// channelRecipient, channelToken, channelSecret, channelFormat, channelStatus and channelRepos fields
+// invalid channels are skipped and their errors are returned
+func parseChannelsForm(form url.Values, existing *UserNotification) ([]*NotificationChannel, []error) {
+	var channels []*NotificationChannel
+	var errs []error
+	for i, target := range form["channelTarget"] {
+		status := formValueAt(form, "channelStatus", i)
+		target = strings.TrimSpace(target)
+		recipient := formValueAt(form, "channelRecipient", i)
+		if (target == "" && recipient == "") || status == "remove" {
+			continue
+		}
+		ch := &NotificationChannel{
+			Name:    formValueAt(form, "channelName", i),
+			Type:    formValueAt(form, "channelType", i),
+			Target:  target,
+			Enabled: status != "disabled",
+			Repos:   splitList(formValueAt(form, "channelRepos", i)),
+
+			Recipient: recipient,
+			Token:     formValueAt(form, "channelToken", i),
+			Format:    formValueAt(form, "channelFormat", i),
+			Secret:    formValueAt(form, "channelSecret", i),
+			Template:  formValueAt(form, "channelTemplate", i),
+			Priority:  formValueAt(form, "channelPriority", i),
+			Tags:      splitList(formValueAt(form, "channelTags", i)),
+		}
+		ch.keepSecrets(existing)
+		if err := ch.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		channels = append(channels, ch)
+	}
+	return cleanChannels(channels), errs
+}
+
+// splitList splits a comma separated list and drops the empty values
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func formValueAt(form url.Values, key string, i int) string {
+	if len(form[key]) > i {
+		return strings.TrimSpace(form[key][i])
+	}
+	return ""
+}
+
 func convertTzOffsetToInt(offset string) int {
 	intOffset := 1
 	if offset[0:1] == "-" {
@@ -157,6 +239,17 @@ func convertTzOffsetToInt(offset string) int {
 	return (intOffset*hour*60 + minute) * 60
 }
 
+// tzNameForOffset picks the first TimeZone Name for an offset of the form +0530
+func tzNameForOffset(tz string) string {
+	offset := convertTzOffsetToInt(tz)
+	timeZoneNames := tzByOffset[offset]
+	if len(timeZoneNames) > 0 {
+		return timeZoneNames[0].Location
+	}
+	log.Println("Could not find TimeZone Name for ", tz)
+	return "UTC"
+}
+
 type invalidTimezone struct{}
 
 func (invalidTimezone) Error() string {
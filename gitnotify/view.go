@@ -27,6 +27,8 @@ func InitView() {
 		"shortCommit":      shortCommit,
 		"cleanRepoName":    cleanRepoName,
 		"WebhooksList":     WebhooksList,
+		"ChannelTypes":     ChannelTypes,
+		"ChannelFormats":   ChannelFormats,
 		"capitalizeOrNone": capitalizeOrNone,
 	}
 	kinli.ClientConfig = map[string]string{
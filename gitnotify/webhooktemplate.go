@@ -0,0 +1,294 @@
+package gitnotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Generic webhooks post the diff as JSON by default. A channel can instead have a text/template
+// which renders the JSON expected by the receiver from webhookTemplateData, eg:
+//
+//	{"title": {{ json .Summary }}, "link": {{ json .ChangesURL }},
+//	 "repos": [{{ range $i, $d := .Diffs }}{{ if $i }},{{ end }}{{ json $d.Repo.Text }}{{ end }}]}
+const genericChannelType = "generic"
+
+const (
+	// maximum size of the template saved with the channel
+	webhookTemplateMaxSize = 8192
+	// maximum size of the payload rendered by the template
+	webhookPayloadMaxSize = 512 * 1024
+	// ranges can be nested over .Diffs, their .Data and the .Changes
+	webhookTemplateMaxRangeDepth = 3
+	// maximum width and precision of the printf verbs
+	webhookPrintfMaxWidth = 1000
+)
+
+// errPayloadTooLarge stops the template as soon as the payload is larger than webhookPayloadMaxSize
+var errPayloadTooLarge = fmt.Errorf("payload is larger than %d bytes", webhookPayloadMaxSize)
+
+// width/precision of the printf verbs, eg: %-10.2f
+var printfWidthRegex = regexp.MustCompile(`%[-+# 0]*(?:\[\d+\])?([0-9*]*)(?:\.(?:\[\d+\])?([0-9*]*))?`)
+
+// webhookTemplateData is available to the payload templates
+type webhookTemplateData struct {
+	Event      string
+	DeliveryID string
+	ChangesURL string
+	Summary    string
+	Diffs      gnDiffDatum
+}
+
+// webhookTemplateFuncs are the only functions available to the payload templates
+// since they are written by the users and executed on the server
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		out, err := json.Marshal(v)
+		return string(out), err
+	},
+	"join":     strings.Join,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"trim":     strings.TrimSpace,
+	"replace":  webhookReplace,
+	"truncate": func(max int, s string) string { return truncateString(s, max) },
+	"now":      func() string { return time.Now().UTC().Format(time.RFC3339) },
+	// replaces the builtin printf, whose width can allocate any size
+	"printf": webhookPrintf,
+}
+
+// webhookReplace is strings.Replace which fails when the result would be larger than the payload
+func webhookReplace(s, old, new string, n int) (string, error) {
+	count := strings.Count(s, old)
+	if n >= 0 && n < count {
+		count = n
+	}
+	if len(s)+count*len(new) > webhookPayloadMaxSize {
+		return "", errPayloadTooLarge
+	}
+	return strings.Replace(s, old, new, n), nil
+}
+
+// webhookPrintf is fmt.Sprintf with the width and precision limited to webhookPrintfMaxWidth
+func webhookPrintf(format string, args ...interface{}) (string, error) {
+	for _, m := range printfWidthRegex.FindAllStringSubmatch(format, -1) {
+		for _, n := range m[1:] {
+			if strings.Contains(n, "*") {
+				return "", errors.New("printf: * width is not allowed")
+			}
+			if width, _ := strconv.Atoi(n); width > webhookPrintfMaxWidth {
+				return "", fmt.Errorf("printf: width should be less than %d", webhookPrintfMaxWidth)
+			}
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// limitedWriter fails the execution of the template once the payload is larger than max
+type limitedWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.max {
+		return 0, errPayloadTooLarge
+	}
+	return w.Buffer.Write(p)
+}
+
+func newWebhookTemplateData(diffs gnDiffDatum, deliveryID, fileName string) *webhookTemplateData {
+	data := &webhookTemplateData{
+		Event:      webhookEventChanges,
+		DeliveryID: deliveryID,
+		Diffs:      diffs,
+	}
+	if fileName != "" {
+		data.ChangesURL = config.websiteURL() + "/changes/" + fileName
+	}
+	var repos []string
+	for _, diff := range diffs {
+		if diff.Changed {
+			repos = append(repos, diff.Repo.Text)
+		}
+	}
+	data.Summary = fmt.Sprintf("Changes in %d repositories: %s", len(repos), strings.Join(repos, ", "))
+	return data
+}
+
+// renderWebhookTemplate executes the payload template and checks that it produced JSON
+func renderWebhookTemplate(text string, data *webhookTemplateData) (json.RawMessage, error) {
+	t, err := template.New("payload").Option("missingkey=error").Funcs(webhookTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkWebhookTemplate(t); err != nil {
+		return nil, err
+	}
+	out := &limitedWriter{max: webhookPayloadMaxSize}
+	if err = t.Execute(out, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(out.Bytes()) {
+		return nil, fmt.Errorf("payload is not valid JSON: %s", truncateString(out.String(), 200))
+	}
+	return json.RawMessage(out.Bytes()), nil
+}
+
+// checkWebhookTemplate rejects the templates whose execution is not bounded by the size of the changes:
+// ranges over numbers, ranges nested deeper than the changes and calls of the templates, which can recurse
+func checkWebhookTemplate(t *template.Template) error {
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil {
+			continue
+		}
+		if err := checkTemplateNode(tmpl.Tree.Root, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkTemplateNode(node parse.Node, depth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child, depth); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranchNode(&n.BranchNode, depth)
+	case *parse.WithNode:
+		return checkBranchNode(&n.BranchNode, depth)
+	case *parse.RangeNode:
+		if depth == webhookTemplateMaxRangeDepth {
+			return fmt.Errorf("range should not be nested more than %d levels", webhookTemplateMaxRangeDepth)
+		}
+		if isNumberPipe(n.Pipe) {
+			return errors.New("range should be over the changes, not a number")
+		}
+		if err := checkTemplateNode(n.List, depth+1); err != nil {
+			return err
+		}
+		return checkTemplateNode(n.ElseList, depth)
+	case *parse.TemplateNode:
+		return errors.New("template calls are not allowed")
+	}
+	return nil
+}
+
+func checkBranchNode(n *parse.BranchNode, depth int) error {
+	if err := checkTemplateNode(n.List, depth); err != nil {
+		return err
+	}
+	return checkTemplateNode(n.ElseList, depth)
+}
+
+// isNumberPipe is true when the pipeline can be a number, like 1000 or a variable assigned from it
+func isNumberPipe(pipe *parse.PipeNode) bool {
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.NumberNode:
+				return true
+			case *parse.VariableNode:
+				if len(a.Ident) == 1 && a.Ident[0] != "$" {
+					return true
+				}
+			case *parse.PipeNode:
+				if isNumberPipe(a) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// validateGenericChannel checks the payload template against a sample of the changes
+func validateGenericChannel(ch *NotificationChannel, label string) error {
+	if strings.TrimSpace(ch.Template) == "" {
+		ch.Template = ""
+		return nil
+	}
+	if len(ch.Template) > webhookTemplateMaxSize {
+		return fmt.Errorf("Channel %s: payload template should be less than %d characters", label, webhookTemplateMaxSize)
+	}
+	if _, err := renderWebhookTemplate(ch.Template, newWebhookTemplateData(sampleDiff(), "sample", "0")); err != nil {
+		return fmt.Errorf("Channel %s: payload template is invalid: %s", label, err)
+	}
+	return nil
+}
+
+// genericRequests posts the diff or the payload rendered by the template of the channel
+func genericRequests(diffs gnDiffDatum, ch *NotificationChannel, deliveryID, fileName string) ([]*deliveryRequest, error) {
+	var data interface{} = diffs
+	if ch.Template != "" {
+		payload, err := renderWebhookTemplate(ch.Template, newWebhookTemplateData(diffs, deliveryID, fileName))
+		if err != nil {
+			return nil, err
+		}
+		data = payload
+	}
+	req, err := signedWebhookRequest(ch.Target, webhookEventChanges, deliveryID, data)
+	if err != nil {
+		return nil, err
+	}
+	return []*deliveryRequest{req}, nil
+}
+
+// webhookPreview renders the payload of the channel for the latest saved changes of the user
+func webhookPreview(conf *Setting, ch *NotificationChannel) string {
+	fileName, diffs := latestDiff(conf)
+	if fileName == "" {
+		fileName, diffs = "0", sampleDiff()
+	}
+	reqs, err := genericRequests(diffs, ch, "preview", fileName)
+	if err != nil {
+		return err.Error()
+	}
+	return reqs[0].PrettyBody()
+}
+
+// latestDiff loads the most recent changes saved for the user
+func latestDiff(conf *Setting) (string, gnDiffDatum) {
+	files := (&gnDiffDatum{}).ListUserChanges(conf)
+	if len(files) == 0 {
+		return "", nil
+	}
+	fileName := fmt.Sprintf("%d", files[0].Reference)
+	diffs := gnDiffDatum{}
+	if err := diffs.load(fileName, conf); err != nil {
+		return "", nil
+	}
+	return fileName, diffs
+}
+
+// sampleDiff is used to check the templates when the user has no saved changes
+func sampleDiff() gnDiffDatum {
+	return gnDiffDatum{
+		&gnDiffData{
+			Repo:    link{Text: "rails/rails", Href: "https://github.com/rails/rails"},
+			Changed: true,
+			Data: []diffData{
+				{
+					Title:      link{Text: "tags", Href: "https://github.com/rails/rails/tags", Title: "New Tags: "},
+					ChangeType: "repoRefDiff",
+					Changed:    true,
+					Changes:    []link{{Text: "v7.0.0", Href: "https://github.com/rails/rails/tree/v7.0.0"}},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package gitnotify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderWebhookTemplate(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com"}
+
+	data := newWebhookTemplateData(gnDiffDatum{testRepoDiff("rails/rails"), {Repo: link{Text: "golang/go"}}}, "1-a", "42")
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		err      string
+	}{
+		{"fields", `{"event": {{ json .Event }}, "id": {{ json .DeliveryID }}, "url": {{ json .ChangesURL }}}`,
+			`{"event": "changes", "id": "1-a", "url": "https://gitnotify.com/changes/42"}`, ""},
+		{"summary", `{"text": {{ json .Summary }}}`, `{"text": "Changes in 1 repositories: rails/rails"}`, ""},
+		{"functions", `{"repo": {{ json (upper (truncate 8 (index .Diffs 0).Repo.Text)) }}}`, `{"repo": "RAILS…"}`, ""},
+		{"escaped", `{"repos": [{{ range $i, $d := .Diffs }}{{ if $i }},{{ end }}{{ json $d.Repo.Text }}{{ end }}]}`,
+			`{"repos": ["rails/rails","golang/go"]}`, ""},
+		{"not json", `text: {{ .Summary }}`, "", "payload is not valid JSON"},
+		{"missing field", `{"x": {{ json .Secret }}}`, "", "can't evaluate field Secret"},
+		{"unknown function", `{"x": {{ env "HOME" }}}`, "", `function "env" not defined`},
+		{"printf", `{"x": {{ json (printf "%5s|%.2f" "a" 1.5) }}}`, `{"x": "    a|1.50"}`, ""},
+		{"printf width", `{"x": {{ json (printf "%600000s" "") }}}`, "", "width should be less than"},
+		{"printf star", `{"x": {{ json (printf "%*s" 600000 "") }}}`, "", "* width is not allowed"},
+		{"printf indexed star", `{"x": {{ json (printf "%[1]*[2]s" 600000 "") }}}`, "", "* width is not allowed"},
+		{"replace", `{"x": {{ json (replace (printf "%1000s" "") " " (printf "%1000s" "") -1) }}}`, "", "payload is larger than"},
+		{"range over a number", `[{{ range 1000000000 }}1,{{ end }}1]`, "", "not a number"},
+		{"range over a variable", `[{{ $n := 1000000000 }}{{ range $n }}1,{{ end }}1]`, "", "not a number"},
+		{"nested ranges", `[{{ range .Diffs }}{{ range .Data }}{{ range .Changes }}{{ range $.Diffs }}1,{{ end }}{{ end }}{{ end }}{{ end }}1]`, "", "should not be nested"},
+		{"template call", `{{ define "x" }}{{ template "x" . }}{{ end }}{{ template "x" . }}`, "", "template calls are not allowed"},
+	}
+	for _, tt := range tests {
+		got, err := renderWebhookTemplate(tt.template, data)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected the error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: expected %s, got %s %v", tt.name, tt.want, got, err)
+		}
+	}
+}
+
+func TestRenderWebhookTemplateLimit(t *testing.T) {
+	oldConfig := config
+	defer func() { config = oldConfig }()
+	config = &AppConfig{ServerProto: "https", ServerHost: "gitnotify.com"}
+
+	data := newWebhookTemplateData(gnDiffDatum{testTagsDiff("rails/rails", 20000)}, "1-a", "42")
+	start := time.Now()
+	_, err := renderWebhookTemplate(`[{{ range .Diffs }}{{ range .Data }}{{ range $.Diffs }}{{ json . }},{{ end }}{{ end }}{{ end }}1]`, data)
+	if err == nil || !strings.Contains(err.Error(), "payload is larger than") {
+		t.Errorf("expected the payload to be too large, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the template to stop at the limit, took %s", elapsed)
+	}
+}
+
+func TestValidateGenericChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		valid    bool
+		saved    string
+	}{
+		{"default payload", "", true, ""},
+		{"blank", "  \n", true, ""},
+		{"template", `{"text": {{ json .Summary }}}`, true, `{"text": {{ json .Summary }}}`},
+		{"invalid", `{"text": {{ .Summary }}}`, false, ""},
+		{"too long", strings.Repeat(" ", webhookTemplateMaxSize) + "{}", false, ""},
+	}
+	for _, tt := range tests {
+		ch := &NotificationChannel{Type: genericChannelType, Template: tt.template}
+		err := validateGenericChannel(ch, "hook")
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.valid && ch.Template != tt.saved {
+			t.Errorf("%s: expected the template %q to be saved, got %q", tt.name, tt.saved, ch.Template)
+		}
+	}
+}
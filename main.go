@@ -1,8 +1,16 @@
 package main
 
-import "github.com/sairam/gitnotify/gitnotify"
+import (
+	"os"
+
+	"github.com/sairam/gitnotify/gitnotify"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(gitnotify.RunCommand(os.Args[1:]))
+	}
+
 	gitnotify.LoadConfig("config.yml")
 	gitnotify.InitMail()
 	go gitnotify.InitCron()